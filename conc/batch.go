@@ -0,0 +1,99 @@
+package conc
+
+import "fmt"
+
+// MapToBatchSink calls the function with each value of the slice, and calls flush with the results in batches
+// of batchSize, in the same order as the input. The last batch contains the remaining results, and might be smaller
+// flush is never called concurrently, and is called as soon as a batch with all previous results is complete,
+// so the results are never buffered as a whole. An error returned by flush aborts the call
+// Since there is no result slice, settings that operate on it, like WithResultValidator, return an error, and so do
+// settings that keep going after a value has failed, like WithContinueOnError
+func MapToBatchSink[TYPE any, RET any](
+	ss []TYPE,
+	fn func(TYPE) (RET, error),
+	flush func([]RET) error,
+	batchSize int,
+	settings ...MapSetting,
+) error {
+	if batchSize < 1 {
+		return fmt.Errorf("batch size can't be less than 1, was %d", batchSize)
+	}
+
+	options, err := newMapOptions(len(ss), settings)
+	if err != nil {
+		return err
+	}
+	if err := unsupportedSettings("MapToBatchSink", options.resultSettings(), options.continueSettings()); err != nil {
+		return err
+	}
+
+	batch := make([]RET, 0, batchSize)
+	committer := newOrderedCommitter(0, func(index int, r RET) error {
+		batch = append(batch, r)
+		if len(batch) < batchSize {
+			return nil
+		}
+		full := batch
+		batch = make([]RET, 0, batchSize)
+		return flush(full)
+	})
+	options.precondition = committer.skipping(options.precondition)
+	err = run(ss, 0, func(i int, v TYPE) error {
+		r, err := fn(v)
+		if err != nil {
+			return err
+		}
+		return committer.add(i, r)
+	}, options)
+	committer.close()
+	if err != nil {
+		return err
+	}
+
+	if len(batch) > 0 {
+		return flush(batch)
+	}
+	return nil
+}
+
+// MapBatch splits the slice into batches of batchSize values, and calls the function concurrently with each batch
+// The last batch contains the remaining values, and might be smaller. The function has to return exactly one result
+// per value of the batch, and the results of all batches are returned flattened, in the same order as the input
+// The max concurrency limits the number of batches processed at the same time, and errors and panics are handled
+// in the same way as with Map
+func MapBatch[TYPE any, RET any](
+	ss []TYPE,
+	batchSize int,
+	fn func([]TYPE) ([]RET, error),
+	settings ...MapSetting,
+) ([]RET, error) {
+	if batchSize < 1 {
+		return nil, fmt.Errorf("batch size can't be less than 1, was %d", batchSize)
+	}
+
+	batches := make([][]TYPE, 0, (len(ss)+batchSize-1)/batchSize)
+	for start := 0; start < len(ss); start += batchSize {
+		end := min(start+batchSize, len(ss))
+		batches = append(batches, ss[start:end:end])
+	}
+
+	results, err := Map(batches, func(batch []TYPE) ([]RET, error) {
+		r, err := fn(batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(r) != len(batch) {
+			return nil, fmt.Errorf("batch of %d values returned %d results", len(batch), len(r))
+		}
+		return r, nil
+	}, settings...)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]RET, 0, len(ss))
+	for _, r := range results {
+		ret = append(ret, r...)
+	}
+	return ret, nil
+}
@@ -0,0 +1,38 @@
+package conc
+
+// MapIntoBuilder calls the function with each value of the slice, and accumulates the results into acc by calling
+// appendFn, instead of returning a slice. appendFn is called in input order, and never concurrently, as soon as all
+// previous results has been appended. This is useful when building something else than a slice, like a strings.Builder
+// If an error occurs, acc will only contain the results that were appended before it
+// Settings that operate on a result slice, like WithWorkQueue or WithResumeFrom, or keep going after a value has
+// failed, like WithContinueOnError, are not supported
+func MapIntoBuilder[TYPE any, RET any, ACC any](
+	ss []TYPE,
+	fn func(TYPE) (RET, error),
+	appendFn func(acc *ACC, index int, r RET),
+	acc *ACC,
+	settings ...MapSetting,
+) error {
+	options, err := newMapOptions(len(ss), settings)
+	if err != nil {
+		return err
+	}
+	if err := unsupportedSettings("MapIntoBuilder", options.resultSettings(), options.continueSettings()); err != nil {
+		return err
+	}
+
+	committer := newOrderedCommitter(0, func(index int, r RET) error {
+		appendFn(acc, index, r)
+		return nil
+	})
+	options.precondition = committer.skipping(options.precondition)
+	err = run(ss, 0, func(i int, v TYPE) error {
+		r, err := fn(v)
+		if err != nil {
+			return err
+		}
+		return committer.add(i, r)
+	}, options)
+	committer.close()
+	return err
+}
@@ -0,0 +1,17 @@
+package conc
+
+// MapCallback works like Map, but also calls onResult with the index and result of each value as soon as it
+// has been processed successfully, without waiting for the rest of the values. onResult is never called for
+// values that made the function return an error or panic. The calls to onResult are serialized, so it does
+// not have to be safe for concurrent use, but a slow onResult will hold back the workers
+func MapCallback[TYPE any, RET any](
+	ss []TYPE,
+	fn func(TYPE) (RET, error),
+	onResult func(i int, r RET),
+	settings ...MapSetting,
+) ([]RET, error) {
+	settings = append(settings[:len(settings):len(settings)], func(mo *mapOptions) {
+		mo.onResult = onResult
+	})
+	return Map(ss, fn, settings...)
+}
@@ -0,0 +1,208 @@
+package conc
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// MapChanOrdered calls the function with each value read from in, until it's closed, and emits the results on the
+// returned channel in the same order as the values were read. Results that are done out of order are buffered
+// until all previous results has been emitted. No more values than the max concurrency are read from in without
+// their results having been emitted, so it bounds both the values being processed and the buffered results
+// Without WithMaxConcurrency, the max concurrency is runtime.GOMAXPROCS
+// Values skipped by WithPrecondition are left out of the results
+// If an error occurs, reading from in stops, and the error is sent on the error channel after the result channel
+// has been closed. The result channel has to be drained, or the context cancelled, for the workers to be able to finish
+// The same settings as for MapSeq are supported, except the ones that keep going after a value has failed,
+// like WithContinueOnError
+func MapChanOrdered[TYPE any, RET any](
+	in <-chan TYPE,
+	fn func(TYPE) (RET, error),
+	settings ...MapSetting,
+) (<-chan RET, <-chan error) {
+	out := make(chan RET)
+	errs := make(chan error, 1)
+
+	options, err := newMapOptions(math.MaxInt, settings)
+	if err == nil {
+		err = checkSeqSettings("MapChanOrdered", options)
+	}
+	if err == nil {
+		err = unsupportedSettings("MapChanOrdered", options.continueSettings())
+	}
+	if options.maxConcurrency == math.MaxInt {
+		options.maxConcurrency = runtime.GOMAXPROCS(0)
+	}
+	// The values read from in that hasn't been emitted yet, either because they are being processed or are waiting
+	// for an earlier result, are limited to the max concurrency. Slots are acquired in the order the values are read,
+	// so the next result to be emitted always has one
+	var slots *Semaphore
+	if err == nil {
+		slots, err = NewSemaphore(options.maxConcurrency)
+	}
+	if err != nil {
+		close(out)
+		errs <- err
+		close(errs)
+		return out, errs
+	}
+
+	// runCtx is cancelled as soon as runSeq stops, to stop reading from in
+	var runCtx context.Context
+	options.doneCtxs = append(options.doneCtxs[:len(options.doneCtxs):len(options.doneCtxs)], &runCtx)
+
+	seq := func(yield func(TYPE) bool) {
+		for {
+			if slots.Acquire(runCtx) != nil {
+				return
+			}
+			select {
+			case v, ok := <-in:
+				if !ok || !yield(v) {
+					return
+				}
+			case <-runCtx.Done():
+				return
+			}
+		}
+	}
+	committer := newOrderedCommitter(0, func(_ int, r RET) error {
+		select {
+		case out <- r:
+			slots.Release()
+			return nil
+		case <-options.ctx.Done():
+			return options.ctx.Err()
+		}
+	})
+	// A value skipped by the precondition is never emitted, so it releases its slot right away
+	if precondition := committer.skipping(options.precondition); precondition != nil {
+		options.precondition = func(index int) (bool, error) {
+			ok, err := precondition(index)
+			if err == nil && !ok {
+				slots.Release()
+			}
+			return ok, err
+		}
+	}
+
+	go func() {
+		err := runSeq(seq, func(i int, v TYPE) error {
+			r, err := fn(v)
+			if err != nil {
+				return err
+			}
+			return committer.add(i, r)
+		}, options)
+		committer.close()
+
+		close(out)
+		if err != nil {
+			errs <- err
+		}
+		close(errs)
+	}()
+
+	return out, errs
+}
+
+// MapChan calls the function with each value of the slice, and emits the results on the returned channel as soon
+// as they are done. Use WithOrderedResults to emit them in input order. An error returned by the function is emitted
+// as the Err of the result for that value, and does not stop other values from being processed
+// The channel is closed once all values are done, or the context is cancelled. It has to be drained, or the context
+// cancelled, for the workers to be able to finish. An error is only returned if the settings are invalid, or if
+// settings that operate on a result slice, like WithResultValidator, or on the errors returned by the function,
+// like WithRetry, are used. With WithOutputChannel, the results are sent on the given channel instead, and the
+// returned channel is closed without any results once all results have been sent
+func MapChan[TYPE any, RET any](
+	ss []TYPE,
+	fn func(TYPE) (RET, error),
+	settings ...MapSetting,
+) (<-chan Result[RET], error) {
+	options, err := newMapOptions(len(ss), settings)
+	if err != nil {
+		return nil, err
+	}
+	// The errors returned by fn are emitted as results, so the settings that handle errors would never see them
+	if err := unsupportedSettings("MapChan", options.resultSettings(), []namedSetting{
+		{"WithRetry", options.retryAttempts > 1},
+		{"WithCircuitBreaker", options.circuitBreaker > 0},
+		{"WithMaxErrors", options.maxErrors > 0},
+		{"WithFailureThreshold", options.failureThreshold != nil},
+	}); err != nil {
+		return nil, err
+	}
+
+	out := make(chan Result[RET])
+	results := (chan<- Result[RET])(out)
+	output, err := typedSetting[chan<- Result[RET]](options.outputChannel, "output channel")
+	if err != nil {
+		return nil, err
+	}
+	if output != nil {
+		results = output
+	}
+
+	// Results sent by workers that are still running when the context is cancelled are dropped, instead of
+	// being sent on a closed channel
+	lock := sync.Mutex{}
+	closed := false
+	sending := sync.WaitGroup{}
+	send := func(r Result[RET]) error {
+		lock.Lock()
+		if closed {
+			lock.Unlock()
+			return nil
+		}
+		sending.Add(1)
+		lock.Unlock()
+		defer sending.Done()
+
+		select {
+		case results <- r:
+		case <-options.ctx.Done():
+		}
+		return nil
+	}
+	committer := newOrderedCommitter(0, func(_ int, r Result[RET]) error {
+		return send(r)
+	})
+	if options.orderedResults {
+		options.precondition = committer.skipping(options.precondition)
+	}
+
+	go func() {
+		err := run(ss, 0, func(i int, v TYPE) error {
+			r, err := fn(v)
+			result := Result[RET]{Index: i, Value: r, Err: err}
+			if options.orderedResults {
+				return committer.add(i, result)
+			}
+			return send(result)
+		}, options)
+		committer.close()
+		if err != nil && !isCancellation(err) {
+			_ = send(Result[RET]{Index: -1, Err: err})
+		}
+
+		lock.Lock()
+		closed = true
+		lock.Unlock()
+		sending.Wait()
+		close(out)
+	}()
+
+	return out, nil
+}
+
+// WithOutputChannel makes MapChan send the results on ch, instead of on a new channel, which makes it possible to
+// reuse the same channel across multiple calls. The channel is owned by the caller and is never closed by MapChan,
+// which instead closes the channel it returns, once all results have been sent on ch
+// The result type of the channel has to match the result type of the function, otherwise an error is returned
+func WithOutputChannel[RET any](ch chan<- Result[RET]) MapSetting {
+	return func(mo *mapOptions) {
+		mo.outputChannel = ch
+	}
+}
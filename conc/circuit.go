@@ -0,0 +1,77 @@
+package conc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrCircuitOpen is the error that errors returned by a tripped circuit breaker matches with `errors.Is`
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitOpenError is returned when the circuit breaker is tripped
+// Errs contains the consecutive errors that tripped it, in the order they were returned
+type CircuitOpenError struct {
+	Errs []error
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s after %d consecutive errors, last error: %v", ErrCircuitOpen, len(e.Errs), e.Unwrap())
+}
+
+// Is makes it possible to use `errors.Is(err, ErrCircuitOpen)`
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// Unwrap returns the last error that tripped the circuit breaker
+func (e *CircuitOpenError) Unwrap() error {
+	return e.Errs[len(e.Errs)-1]
+}
+
+// WithCircuitBreaker makes Map continue past errors from the function, until `consecutive` errors has been
+// returned in a row (by completion order). At that point, the remaining work is cancelled and a *CircuitOpenError
+// is returned. If the circuit breaker never trips, the first error encountered is returned once all values are processed
+func WithCircuitBreaker(consecutive int) MapSetting {
+	return func(mo *mapOptions) {
+		mo.circuitBreaker = consecutive
+	}
+}
+
+// circuitBreaker keeps track of consecutive errors, it's safe for concurrent use
+type circuitBreaker struct {
+	threshold int
+
+	lock     sync.Mutex
+	firstErr error
+	errs     []error
+}
+
+// failure records an error, and returns a non-nil error if the circuit breaker was tripped by it
+func (cb *circuitBreaker) failure(err error) error {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.firstErr == nil {
+		cb.firstErr = err
+	}
+	cb.errs = append(cb.errs, err)
+	if len(cb.errs) >= cb.threshold {
+		return &CircuitOpenError{Errs: append([]error(nil), cb.errs...)}
+	}
+	return nil
+}
+
+// success resets the consecutive error count
+func (cb *circuitBreaker) success() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.errs = nil
+}
+
+// err returns the first error ever recorded
+func (cb *circuitBreaker) err() error {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.firstErr
+}
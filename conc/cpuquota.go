@@ -0,0 +1,71 @@
+package conc
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup file system is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// WithMaxConcurrencyFromCPUQuota sets the max concurrency to the CPU quota of the container (cgroup v1 or v2)
+// multiplied by multiplier, rounded up. When no CPU quota is set, like when not running in a container, the max
+// concurrency is set to fallback instead
+// This is useful in containers, where runtime.GOMAXPROCS might not reflect the actual CPU quota
+func WithMaxConcurrencyFromCPUQuota(multiplier float64, fallback int) MapSetting {
+	return func(mo *mapOptions) {
+		mo.maxConcurrency = concurrencyFromCPUQuota(cgroupRoot, multiplier, fallback)
+	}
+}
+
+// concurrencyFromCPUQuota calculates the concurrency from the CPU quota in the cgroup file system mounted at root
+func concurrencyFromCPUQuota(root string, multiplier float64, fallback int) int {
+	quota, ok := cpuQuota(root)
+	if !ok {
+		return fallback
+	}
+	concurrency := int(math.Ceil(quota * multiplier))
+	if concurrency < 1 {
+		return 1
+	}
+	return concurrency
+}
+
+// cpuQuota reads the CPU quota, as a number of CPUs, from the cgroup file system mounted at root
+// If no quota is set, or it can't be read, false is returned
+func cpuQuota(root string) (float64, bool) {
+	// cgroup v2 has both the quota and the period in cpu.max, as "$MAX $PERIOD", where $MAX might be "max"
+	if b, err := os.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		fields := strings.Fields(string(b))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		return parseCPUQuota(fields[0], fields[1])
+	}
+
+	// cgroup v1 has the quota and period in separate files, where a quota of -1 means no limit
+	quota, err := os.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, false
+	}
+	period, err := os.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, false
+	}
+	return parseCPUQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+func parseCPUQuota(quotaStr, periodStr string) (float64, bool) {
+	quota, err := strconv.ParseFloat(quotaStr, 64)
+	if err != nil || quota <= 0 {
+		return 0, false
+	}
+	period, err := strconv.ParseFloat(periodStr, 64)
+	if err != nil || period <= 0 {
+		return 0, false
+	}
+	return quota / period, true
+}
@@ -0,0 +1,5 @@
+package conc
+
+// Exported for testing
+
+var ConcurrencyFromCPUQuota = concurrencyFromCPUQuota
@@ -0,0 +1,47 @@
+package conc
+
+// Filter calls the predicate concurrently with each value of the slice, and returns the values where it returned
+// true, in the same order as the input. Errors and panics are handled in the same way as with Map
+// Settings that operate on the result slice are not supported, except WithInputSnapshot
+func Filter[TYPE any](ss []TYPE, pred func(TYPE) (bool, error), settings ...MapSetting) ([]TYPE, error) {
+	ss, settings, err := indexedInput("Filter", ss, settings)
+	if err != nil {
+		return nil, err
+	}
+	keep, err := Map(ss, pred, settings...)
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []TYPE
+	for i, k := range keep {
+		if k {
+			filtered = append(filtered, ss[i])
+		}
+	}
+	return filtered, nil
+}
+
+// Partition calls the predicate concurrently with each value of the slice, and splits the values into the ones where
+// it returned true and the ones where it returned false, both in the same order as the input
+// Errors and panics are handled in the same way as with Map
+// Settings that operate on the result slice are not supported, except WithInputSnapshot
+func Partition[TYPE any](ss []TYPE, pred func(TYPE) (bool, error), settings ...MapSetting) (matched []TYPE, unmatched []TYPE, err error) {
+	ss, settings, err = indexedInput("Partition", ss, settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	match, err := Map(ss, pred, settings...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, m := range match {
+		if m {
+			matched = append(matched, ss[i])
+		} else {
+			unmatched = append(unmatched, ss[i])
+		}
+	}
+	return matched, unmatched, nil
+}
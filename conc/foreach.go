@@ -0,0 +1,27 @@
+package conc
+
+// ForEach calls the function with each value of the slice, with the same concurrency, context and error handling as Map
+// Since no results are kept, settings that operate on a result slice, like WithResultValidator or WithWorkQueue,
+// return an error
+func ForEach[TYPE any](
+	ss []TYPE,
+	fn func(TYPE) error,
+	settings ...MapSetting,
+) error {
+	return forEach("ForEach", ss, fn, settings)
+}
+
+// forEach is the implementation of ForEach, and of the other functions without results
+func forEach[TYPE any](fnName string, ss []TYPE, fn func(TYPE) error, settings []MapSetting) error {
+	options, err := newMapOptions(len(ss), settings)
+	if err != nil {
+		return err
+	}
+	if err := unsupportedSettings(fnName, options.resultSettings()); err != nil {
+		return err
+	}
+
+	return run(ss, 0, func(_ int, v TYPE) error {
+		return fn(v)
+	}, options)
+}
@@ -0,0 +1,47 @@
+package conc
+
+import (
+	"context"
+	"runtime/debug"
+)
+
+// Future is the result of a function that is run asynchronously with Go
+type Future[RET any] struct {
+	done  chan struct{}
+	value RET
+	err   error
+}
+
+// Go runs the function in a new go-routine, and returns a Future that can be used to wait for its result
+// A panic in the function is recovered, and returned as a PanicError by Await in the same way as with Map
+func Go[RET any](fn func() (RET, error)) *Future[RET] {
+	f := &Future[RET]{done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		defer func() {
+			if r := recover(); r != nil {
+				f.err = &PanicError{Value: r, Stack: debug.Stack(), Index: -1}
+			}
+		}()
+		f.value, f.err = fn()
+	}()
+	return f
+}
+
+// Await waits for the function to return, and returns its result. It can be called any number of times
+func (f *Future[RET]) Await() (RET, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+// AwaitCtx works like Await, but returns ctx.Err() if the context is done before the function has returned
+// The function keeps running in the background until it returns, but nothing is left waiting for it
+func (f *Future[RET]) AwaitCtx(ctx context.Context) (RET, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		var zero RET
+		return zero, ctx.Err()
+	}
+}
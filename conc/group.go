@@ -0,0 +1,51 @@
+package conc
+
+import "fmt"
+
+// MapGrouped works like Map, but returns the results grouped into consecutive chunks of groupSize
+// The last group contains the remaining results, and might be smaller than groupSize
+func MapGrouped[TYPE any, RET any](
+	ss []TYPE,
+	groupSize int,
+	fn func(TYPE) (RET, error),
+	settings ...MapSetting,
+) ([][]RET, error) {
+	if groupSize < 1 {
+		return nil, fmt.Errorf("groupSize can't be less than 1, was %d", groupSize)
+	}
+
+	ret, err := Map(ss, fn, settings...)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([][]RET, 0, (len(ret)+groupSize-1)/groupSize)
+	for len(ret) > groupSize {
+		groups = append(groups, ret[:groupSize:groupSize])
+		ret = ret[groupSize:]
+	}
+	if len(ret) > 0 {
+		groups = append(groups, ret)
+	}
+	return groups, nil
+}
+
+// GroupBy calls keyFn concurrently with each value of the slice, and returns the values grouped by the returned key
+// The values of each group are in the same order as the input. Errors and panics are handled in the same way as with Map
+// Settings that operate on the result slice are not supported, except WithInputSnapshot
+func GroupBy[TYPE any, KEY comparable](ss []TYPE, keyFn func(TYPE) (KEY, error), settings ...MapSetting) (map[KEY][]TYPE, error) {
+	ss, settings, err := indexedInput("GroupBy", ss, settings)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := Map(ss, keyFn, settings...)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[KEY][]TYPE{}
+	for i, key := range keys {
+		groups[key] = append(groups[key], ss[i])
+	}
+	return groups, nil
+}
@@ -0,0 +1,49 @@
+package conc
+
+import (
+	"context"
+	"sync"
+)
+
+// WithConcurrencyPerHost limits the number of values that shares the same host, as returned by hostFn, that can be
+// processed at the same time to perHost, while values of different hosts are processed concurrently as usual
+// This is useful to be polite to the servers when for example crawling a list of URLs
+// Workers waiting for a busy host are counted towards the max concurrency, so it should be set a bit higher
+// The input type of hostFn has to match the input type of the Map function, otherwise an error is returned
+func WithConcurrencyPerHost[TYPE any](perHost int, hostFn func(TYPE) string) MapSetting {
+	return func(mo *mapOptions) {
+		mo.perHost = perHost
+		mo.hostFn = hostFn
+	}
+}
+
+// keyLimiter limits how many can hold the same key at the same time
+type keyLimiter struct {
+	lock  sync.Mutex
+	limit int
+	slots map[string]*Semaphore
+}
+
+func newKeyLimiter(limit int) *keyLimiter {
+	return &keyLimiter{
+		limit: limit,
+		slots: map[string]*Semaphore{},
+	}
+}
+
+// acquire waits until the key is available, or the context is done, and returns the function that releases it
+func (l *keyLimiter) acquire(ctx context.Context, key string) (func(), error) {
+	l.lock.Lock()
+	slots, ok := l.slots[key]
+	if !ok {
+		// The limit is validated by newMapOptions
+		slots, _ = NewSemaphore(l.limit)
+		l.slots[key] = slots
+	}
+	l.lock.Unlock()
+
+	if err := slots.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	return slots.Release, nil
+}
@@ -0,0 +1,52 @@
+package conc
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MapInto works like Map, but writes the results into dst instead of allocating a new slice, which makes it
+// possible to reuse the same slice between calls. dst has to be at least as long as ss
+// If an error is returned, dst contains the results of the values that completed before it, and is not written
+// to after MapInto has returned. Settings that operate on the result slice returned by Map, like WithWorkQueue
+// or WithPartialResults, are not supported since the results are written directly into dst
+func MapInto[TYPE any, RET any](
+	dst []RET,
+	ss []TYPE,
+	fn func(TYPE) (RET, error),
+	settings ...MapSetting,
+) error {
+	if len(dst) < len(ss) {
+		return fmt.Errorf("destination with length %d is shorter than the input with length %d", len(dst), len(ss))
+	}
+
+	options, err := newMapOptions(len(ss), settings)
+	if err != nil {
+		return err
+	}
+	if err := unsupportedSettings("MapInto", options.resultSettings()); err != nil {
+		return err
+	}
+
+	// Values are written with the read lock, since they are independent of each other, and the write lock
+	// stops any more writes once run has returned
+	lock := sync.RWMutex{}
+	sealed := false
+	err = run(ss, 0, func(i int, v TYPE) error {
+		r, err := fn(v)
+		if err != nil {
+			return err
+		}
+		lock.RLock()
+		defer lock.RUnlock()
+		if !sealed {
+			dst[i] = r
+		}
+		return nil
+	}, options)
+
+	lock.Lock()
+	sealed = true
+	lock.Unlock()
+	return err
+}
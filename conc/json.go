@@ -0,0 +1,62 @@
+package conc
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// MapToJSONArray calls the function with each value of the slice, and writes the results as a JSON array to w
+// The results are marshaled concurrently, but written in the same order as the input as soon as all previous
+// results has been written, so the whole array is never buffered in memory
+// If an error occurs, the output written so far will not be a complete JSON array
+// Settings that operate on a result slice, like WithResultDedupe, or keep going after a value has failed, like
+// WithContinueOnError, are not supported
+func MapToJSONArray[TYPE any](
+	ss []TYPE,
+	fn func(TYPE) (any, error),
+	w io.Writer,
+	settings ...MapSetting,
+) error {
+	options, err := newMapOptions(len(ss), settings)
+	if err != nil {
+		return err
+	}
+	if err := unsupportedSettings("MapToJSONArray", options.resultSettings(), options.continueSettings()); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	first := true
+	committer := newOrderedCommitter(0, func(index int, b []byte) error {
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		_, err := w.Write(b)
+		return err
+	})
+	options.precondition = committer.skipping(options.precondition)
+	err = run(ss, 0, func(i int, v TYPE) error {
+		r, err := fn(v)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return committer.add(i, b)
+	}, options)
+	committer.close()
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]")
+	return err
+}
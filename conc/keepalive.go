@@ -0,0 +1,66 @@
+package conc
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrIdleTimeout is returned by MapKeepAlive when a function has not reported any activity in time
+var ErrIdleTimeout = errors.New("idle timeout")
+
+// MapKeepAlive works like Map, but instead of a fixed deadline for each value, the function fails if it
+// has been idle for longer than idleTimeout. The function is given a keepAlive function that should be
+// called whenever progress is made, which resets the idle timer
+// Note that a function that timed out can't be stopped, it will keep running in the background until
+// it returns, and its result is discarded
+func MapKeepAlive[TYPE any, RET any](
+	ss []TYPE,
+	idleTimeout time.Duration,
+	fn func(v TYPE, keepAlive func()) (RET, error),
+	settings ...MapSetting,
+) ([]RET, error) {
+	type result struct {
+		value     RET
+		err       error
+		recovered any
+	}
+
+	return Map(ss, func(v TYPE) (RET, error) {
+		lastActive := time.Now().UnixNano()
+		keepAlive := func() {
+			atomic.StoreInt64(&lastActive, time.Now().UnixNano())
+		}
+
+		resultCh := make(chan result, 1)
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					resultCh <- result{recovered: r}
+				}
+			}()
+			r, err := fn(v, keepAlive)
+			resultCh <- result{value: r, err: err}
+		}()
+
+		timer := time.NewTimer(idleTimeout)
+		defer timer.Stop()
+		for {
+			select {
+			case res := <-resultCh:
+				if res.recovered != nil {
+					panic(res.recovered)
+				}
+				return res.value, res.err
+			case <-timer.C:
+				idle := time.Since(time.Unix(0, atomic.LoadInt64(&lastActive)))
+				if idle >= idleTimeout {
+					var zero RET
+					return zero, fmt.Errorf("%w: no activity for %s", ErrIdleTimeout, idle.Round(time.Millisecond))
+				}
+				timer.Reset(idleTimeout - idle)
+			}
+		}
+	}, settings...)
+}
@@ -2,25 +2,119 @@ package conc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type mapOptions struct {
 	maxConcurrency int
 	ctx            context.Context
+	lockOSThread   bool
+	pprofLabels    map[string]string
+	circuitBreaker int
+	doneCtxs       []*context.Context
+	inputSnapshot  bool
+	logger         *slog.Logger
+	partitioner    func(index int, n int) int
+
+	warmupStart    int
+	warmupStep     int
+	warmupInterval time.Duration
+
+	cancelAfterSuccesses int
+	executionTrace       io.Writer
+
+	checkpointInterval time.Duration
+	checkpoint         func(completed []int)
+	resumeFrom         []int
+
+	inFlightCounter  *int64
+	maxGoroutines    int
+	failOnWorkerLoss bool
+	warnOnZeroResult func(index int)
+	doneBuffer       *int
+	precondition     func(index int) (bool, error)
+	orderedResults   bool
+	scope            *Scope
+	timeout          time.Duration
+	continueOnError  bool
+	assertOrdering   bool
+	failureThreshold *float64
+	compactResults   bool
+	retryAttempts    int
+	backoffBase      time.Duration
+	backoffFactor    float64
+	rateLimit        int
+	ratePer          time.Duration
+	panicHandler     func(recovered any, stack []byte) error
+	indexedErrors    bool
+	progress         func(completed, total int)
+	partialResults   bool
+	maxErrors        int
+
+	completeInFlightOnCancel time.Duration
+	rampDownInterval         time.Duration
+	prefetch                 int
+
+	// dedupe takes the full result slice and returns it without duplicates, it's type-erased
+	// since settings are not aware of the result type
+	dedupe func(results any) (any, error)
+
+	// Settings with functions that depends on the result type are stored as any, and converted with typedSetting
+	resultValidator any
+	interner        any
+	rollback        any
+	workQueue       any
+	discardOnCancel any
+	sampleRate      float64
+	sampleSink      any
+	perHost         int
+	hostFn          any
+	onResult        any
+	outputChannel   any
 }
 
 // MapSetting is a setting for the Map function
 type MapSetting func(*mapOptions)
 
 // WithMaxConcurrency sets the maximum number of concurent go-routines
+// A max concurrency of 0 uses the default, which is one go-routine per value
 func WithMaxConcurrency(maxConcurrency int) MapSetting {
 	return func(mo *mapOptions) {
 		mo.maxConcurrency = maxConcurrency
 	}
 }
 
+// WithMaxProcs sets the max concurrency to runtime.GOMAXPROCS, which is a sane default for CPU bound functions
+func WithMaxProcs() MapSetting {
+	return func(mo *mapOptions) {
+		WithMaxConcurrency(runtime.GOMAXPROCS(0))(mo)
+	}
+}
+
+// WithMaxConcurrencyFraction sets the max concurrency to the fraction f of runtime.GOMAXPROCS, but at least 1
+func WithMaxConcurrencyFraction(f float64) MapSetting {
+	return func(mo *mapOptions) {
+		concurrency := int(f * float64(runtime.GOMAXPROCS(0)))
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		WithMaxConcurrency(concurrency)(mo)
+	}
+}
+
 // WithContext sets the context to be used
 func WithContext(ctx context.Context) MapSetting {
 	return func(mo *mapOptions) {
@@ -28,100 +122,1332 @@ func WithContext(ctx context.Context) MapSetting {
 	}
 }
 
+// WithTimeout sets a timeout for the whole Map call, after which it is cancelled and returns context.DeadlineExceeded
+// It works together with WithContext, whichever of the context being cancelled and the timeout happens first applies
+func WithTimeout(timeout time.Duration) MapSetting {
+	return func(mo *mapOptions) {
+		mo.timeout = timeout
+	}
+}
+
+// WithContinueOnError makes Map process all values, even if the function returns an error for some of them
+// Once all values are processed, the first error is returned. Only that error is returned, not all of them
+// Cancellation of the context, and panics, still aborts Map immediately
+func WithContinueOnError() MapSetting {
+	return func(mo *mapOptions) {
+		mo.continueOnError = true
+	}
+}
+
+// WithRetry makes each value be tried up to attempts times, if the function returns an error, before the error
+// is handled as usual. The retries are made by the same worker, and stops as soon as the context is cancelled
+func WithRetry(attempts int) MapSetting {
+	return func(mo *mapOptions) {
+		mo.retryAttempts = attempts
+	}
+}
+
+// WithBackoff makes WithRetry wait between the attempts of a value, starting with base and growing exponentially
+// by factor, like base, base*factor, base*factor^2 and so on. The wait is interrupted if the context is cancelled
+// It has no effect unless WithRetry is also used
+func WithBackoff(base time.Duration, factor float64) MapSetting {
+	return func(mo *mapOptions) {
+		mo.backoffBase = base
+		mo.backoffFactor = factor
+	}
+}
+
+// WithCompactSuccessful makes Map process all values, even if the function returns an error for some of them,
+// and only return the successful results, in input order without any gaps for the failed values
+// The errors of all failed values are returned joined, in input order. Cancellation of the context, and panics,
+// still aborts Map immediately without any results. Errors from WithResultValidator fails the value like any
+// other error, and a value is only removed once it has failed after all retries
+func WithCompactSuccessful() MapSetting {
+	return func(mo *mapOptions) {
+		mo.compactResults = true
+	}
+}
+
+// ErrFailureThresholdExceeded is returned with WithFailureThreshold when too many values has failed
+var ErrFailureThresholdExceeded = errors.New("failure threshold exceeded")
+
+// WithFailureThreshold makes Map process all values, even if the function returns an error for some of them
+// If the fraction of failed values is at most frac, the results are returned without an error, where the failed
+// values are left as the zero value. Otherwise, an error that matches ErrFailureThresholdExceeded, and wraps the
+// first error, is returned. Cancellation of the context, and panics, still aborts Map immediately
+func WithFailureThreshold(frac float64) MapSetting {
+	return func(mo *mapOptions) {
+		mo.failureThreshold = &frac
+	}
+}
+
+// WithMaxErrors makes Map process values past errors, until the function has returned an error for n of them
+// Once n values have failed, Map is aborted and the errors are returned joined. If all values are processed with
+// fewer failures, the errors of them are returned joined once Map is done. WithMaxErrors(1) is the same as the
+// default of aborting on the first error. Cancellation of the context, and panics, still aborts Map immediately
+func WithMaxErrors(n int) MapSetting {
+	return func(mo *mapOptions) {
+		mo.maxErrors = n
+	}
+}
+
+// WithLockOSThread makes each worker go-routine lock itself to an OS thread for its whole lifetime
+// This is useful when the function depends on thread-local state, for example in cgo-heavy code
+// Note that every worker will occupy its own OS thread, so it should be combined with a reasonable
+// max concurrency, and the locked threads will not be used to run other go-routines until Map is done
+func WithLockOSThread() MapSetting {
+	return func(mo *mapOptions) {
+		mo.lockOSThread = true
+	}
+}
+
+// WithPprofLabels adds the labels to all worker go-routines, making it possible to attribute time
+// spent in goroutine and cpu profiles to a specific Map call. The label "conc_worker" is always set
+// to the index of the worker
+func WithPprofLabels(labels map[string]string) MapSetting {
+	return func(mo *mapOptions) {
+		mo.pprofLabels = labels
+	}
+}
+
+// WithDoneContext populates ctx with a context that is cancelled when Map returns, for any reason
+// This makes it possible to tie the lifetime of other go-routines to the Map call
+func WithDoneContext(ctx *context.Context) MapSetting {
+	return func(mo *mapOptions) {
+		mo.doneCtxs = append(mo.doneCtxs, ctx)
+	}
+}
+
+// WithInputSnapshot makes Map copy the input slice before any values are processed, so that later
+// modifications to the slice, by the caller or a concurrently running go-routine, can't affect the run
+// Note that this allocates a copy of the whole input
+func WithInputSnapshot() MapSetting {
+	return func(mo *mapOptions) {
+		mo.inputSnapshot = true
+	}
+}
+
+// indexedInput prepares the input and settings of the functions that match each value of ss with the result at the
+// same index. Other settings that operate on the result slice, like WithResultDedupe or WithWorkQueue, would break
+// the matching and return an error. With WithInputSnapshot, ss is copied here instead, so that the values returned
+// are the ones the function was called with, and the returned settings don't copy the input again
+func indexedInput[TYPE any](fnName string, ss []TYPE, settings []MapSetting) ([]TYPE, []MapSetting, error) {
+	options := mapOptions{}
+	for _, setting := range settings {
+		setting(&options)
+	}
+	snapshot := options.inputSnapshot
+	options.inputSnapshot = false
+	if err := unsupportedSettings(fnName, options.resultSettings()); err != nil {
+		return nil, nil, err
+	}
+
+	if !snapshot {
+		return ss, settings, nil
+	}
+	return append([]TYPE(nil), ss...), append(settings[:len(settings):len(settings)], func(mo *mapOptions) {
+		mo.inputSnapshot = false
+	}), nil
+}
+
+// WithCompleteInFlightOnCancel changes what happens when the context is cancelled. Instead of returning
+// immediately, no new values are started, but values that are already being processed are given up to
+// max time to finish. The results of the values that did finish are returned together with the context error
+// Values that were never processed, or did not finish in time, will have the zero value in the returned slice
+func WithCompleteInFlightOnCancel(max time.Duration) MapSetting {
+	return func(mo *mapOptions) {
+		mo.completeInFlightOnCancel = max
+	}
+}
+
+// WithRampDownOnShutdown makes the workers stop one at a time, spaced by interval, when Map is aborted by an error
+// or by the context being cancelled, instead of all at once. No new values are started after the shutdown, but
+// workers that haven't been stopped yet keep their go-routine, which smooths out the release of resources like
+// connections. Map returns once the last worker has been stopped, and together with WithCompleteInFlightOnCancel,
+// the values still being processed are then waited for
+func WithRampDownOnShutdown(interval time.Duration) MapSetting {
+	return func(mo *mapOptions) {
+		mo.rampDownInterval = interval
+	}
+}
+
+// WithLogger makes Map log when it starts and finishes at debug level, and each error returned
+// by the function at info level, with the index of the value that failed
+func WithLogger(logger *slog.Logger) MapSetting {
+	return func(mo *mapOptions) {
+		mo.logger = logger
+	}
+}
+
+// WithPartitioner makes sure that all values that belong to the same partition are processed by the same
+// worker, which can be used for cache locality or state kept per partition
+// The partitioner is called with the index of the value and the number of workers n, and should return
+// a partition between 0 and n-1. Values outside of that range are wrapped around
+// Note that a worker that is busy will block other values from being dispatched to other workers
+func WithPartitioner(partitioner func(index int, n int) int) MapSetting {
+	return func(mo *mapOptions) {
+		mo.partitioner = partitioner
+	}
+}
+
+// WithConcurrencyWarmup starts Map with only `start` workers, and then starts `step` more workers every
+// `interval` until `target` workers are running. This avoids overwhelming a downstream with cold caches
+// The target is used as the max concurrency, overriding any previous WithMaxConcurrency
+func WithConcurrencyWarmup(start, target int, step int, interval time.Duration) MapSetting {
+	return func(mo *mapOptions) {
+		mo.warmupStart = start
+		mo.maxConcurrency = target
+		mo.warmupStep = step
+		mo.warmupInterval = interval
+	}
+}
+
+// WithCancelAfterSuccesses stops Map once n values has been processed successfully, no matter which ones
+// The results are returned without an error, and values that did not finish before that have the zero value
+func WithCancelAfterSuccesses(n int) MapSetting {
+	return func(mo *mapOptions) {
+		mo.cancelAfterSuccesses = n
+	}
+}
+
+// WithExecutionTrace writes a human-readable line to w for every value that is dispatched, started and finished,
+// with a timestamp and the index and worker involved. This is meant for debugging of scheduling behavior
+func WithExecutionTrace(w io.Writer) MapSetting {
+	return func(mo *mapOptions) {
+		mo.executionTrace = w
+	}
+}
+
+// WithPartialResults makes Map return the results of the values that completed, together with the error, if it's
+// aborted by an error or cancellation. Values that did not complete have the zero value. To know which values
+// completed, use WithCheckpoint, which is called a final time with them before Map returns
+// It has no effect together with WithRollback, or WithDiscardOnCancel on cancellation, since those discard the results
+func WithPartialResults() MapSetting {
+	return func(mo *mapOptions) {
+		mo.partialResults = true
+	}
+}
+
+// WithCheckpoint calls checkpoint every interval with the indexes of all values that has been completed
+// successfully, in order, and a final time when Map returns. Together with WithResumeFrom, this can be used
+// to resume an interrupted batch
+func WithCheckpoint(interval time.Duration, checkpoint func(completed []int)) MapSetting {
+	return func(mo *mapOptions) {
+		mo.checkpointInterval = interval
+		mo.checkpoint = checkpoint
+	}
+}
+
+// WithResumeFrom skips the values with the completed indexes, which are left with the zero value in the returned slice
+// The skipped indexes are still reported as completed to WithCheckpoint
+func WithResumeFrom(completed []int) MapSetting {
+	return func(mo *mapOptions) {
+		mo.resumeFrom = completed
+	}
+}
+
+// WithInFlightCounter makes Map keep counter updated with the number of values currently being processed
+// The counter is updated atomically, and should be read with atomic.LoadInt64
+func WithInFlightCounter(counter *int64) MapSetting {
+	return func(mo *mapOptions) {
+		mo.inFlightCounter = counter
+	}
+}
+
+// ErrGoroutineBudgetExceeded is returned when starting the workers would exceed the WithMaxGoroutines budget
+var ErrGoroutineBudgetExceeded = errors.New("goroutine budget exceeded")
+
+// WithMaxGoroutines makes Map fail with ErrGoroutineBudgetExceeded, before starting any workers, if the total
+// number of go-routines in the program (as reported by runtime.NumGoroutine) would exceed n
+func WithMaxGoroutines(n int) MapSetting {
+	return func(mo *mapOptions) {
+		mo.maxGoroutines = n
+	}
+}
+
+// WithProgress calls progress each time a value is done, no matter if it succeeded, failed or panicked, with the
+// number of values done so far and the total number of values. It's called from the workers, so it has to be safe
+// for concurrent use, and should be cheap and not block since it holds up the worker
+func WithProgress(progress func(completed, total int)) MapSetting {
+	return func(mo *mapOptions) {
+		mo.progress = progress
+	}
+}
+
+// ElementError is the error returned with WithIndexedErrors, which tells which value the error belongs to
+type ElementError struct {
+	// Index is the index of the value that failed
+	Index int
+	Err   error
+}
+
+func (e *ElementError) Error() string {
+	return fmt.Sprintf("value at index %d: %s", e.Index, e.Err)
+}
+
+func (e *ElementError) Unwrap() error {
+	return e.Err
+}
+
+// WithIndexedErrors wraps the errors returned by the function, and panics, in an ElementError with the index of
+// the value that failed, which can be extracted with errors.As
+func WithIndexedErrors() MapSetting {
+	return func(mo *mapOptions) {
+		mo.indexedErrors = true
+	}
+}
+
+// WithPanicHandler calls handler when the function panics, with the recovered value and the stack of the panic,
+// and the error it returns is used instead of the default panic error. The handler can also panic again, to crash
+// the program instead. If it returns nil, the default panic error is used
+func WithPanicHandler(handler func(recovered any, stack []byte) error) MapSetting {
+	return func(mo *mapOptions) {
+		mo.panicHandler = handler
+	}
+}
+
+// ErrWorkerLost is returned with WithFailOnWorkerLoss when a worker exits unexpectedly
+var ErrWorkerLost = errors.New("worker lost")
+
+// WithFailOnWorkerLoss makes any unexpected exit of a worker, like a panic in the function, abort Map with an
+// error that matches ErrWorkerLost, instead of an error that is indistinguishable from other errors
+// This is especially useful when combined with settings that continue past errors, like WithCircuitBreaker
+func WithFailOnWorkerLoss() MapSetting {
+	return func(mo *mapOptions) {
+		mo.failOnWorkerLoss = true
+	}
+}
+
+// ErrOrderingViolation is returned with WithAssertOrdering when a result has been written more than once
+var ErrOrderingViolation = errors.New("result ordering violation")
+
+// WithAssertOrdering makes Map verify that each result is written exactly once, and abort with an error that
+// matches ErrOrderingViolation otherwise. It guards against internal bugs, and is cheap enough to leave on in production
+// The violation is also logged if WithLogger is used
+func WithAssertOrdering() MapSetting {
+	return func(mo *mapOptions) {
+		mo.assertOrdering = true
+	}
+}
+
+// WithWarnOnZeroResults calls warn with the index of each value where the function returned the zero value
+// without an error, which often is a sign of a forgotten return value
+// warn is called from the workers, so it has to be safe for concurrent use
+func WithWarnOnZeroResults(warn func(index int)) MapSetting {
+	return func(mo *mapOptions) {
+		mo.warnOnZeroResult = warn
+	}
+}
+
+// WithDoneBuffer sets the buffer size of the channel used internally to signal that a value is done, which
+// defaults to the number of values. A smaller buffer uses less memory for large inputs, but makes the workers
+// block more often when signaling. This is an advanced setting that should only be used after measuring
+func WithDoneBuffer(n int) MapSetting {
+	return func(mo *mapOptions) {
+		mo.doneBuffer = &n
+	}
+}
+
+// WithPrecondition calls precondition with the index of each value right before it is dispatched to a worker
+// If false is returned, the value is skipped and left with the zero value, and if an error is returned, Map is aborted
+// Functions without a result slice, like MapToJSONArray or MapChan, leave the skipped values out of the output
+// The precondition is called sequentially, so it should be cheap to not slow down the dispatching
+func WithPrecondition(precondition func(index int) (bool, error)) MapSetting {
+	return func(mo *mapOptions) {
+		mo.precondition = precondition
+	}
+}
+
+// WithResultDedupe removes results that has the same key as a previous result, only keeping the first
+// one (by input index). The returned slice is compacted, but the order of the remaining results is kept
+// The result type of keyFn has to match the result type of the Map function, otherwise an error is returned
+func WithResultDedupe[RET any, KEY comparable](keyFn func(RET) KEY) MapSetting {
+	return func(mo *mapOptions) {
+		mo.dedupe = func(results any) (any, error) {
+			rr, ok := results.([]RET)
+			if !ok {
+				return nil, fmt.Errorf("result dedupe expected results of type %T, got %T", rr, results)
+			}
+
+			seen := make(map[KEY]struct{}, len(rr))
+			deduped := rr[:0]
+			for _, r := range rr {
+				key := keyFn(r)
+				if _, ok := seen[key]; ok {
+					continue
+				}
+				seen[key] = struct{}{}
+				deduped = append(deduped, r)
+			}
+			return deduped, nil
+		}
+	}
+}
+
+// WithResultValidator calls validate with each successful result, and if an error is returned,
+// the value is treated as if the function had returned that error
+// The result type of validate has to match the result type of the Map function, otherwise an error is returned
+func WithResultValidator[RET any](validate func(index int, r RET) error) MapSetting {
+	return func(mo *mapOptions) {
+		mo.resultValidator = validate
+	}
+}
+
+// WithInterner calls intern with each successful result, and stores what it returns instead. This can be
+// used to make equal results share memory, when there are many results but few distinct values
+// Calls to intern are serialized, so it does not have to be safe for concurrent use
+// The result type of intern has to match the result type of the Map function, otherwise an error is returned
+func WithInterner[RET any](intern func(RET) RET) MapSetting {
+	return func(mo *mapOptions) {
+		mo.interner = intern
+	}
+}
+
+// WithRollback calls rollback with each successful result if Map returns an error, in reverse index order
+// This makes it possible to undo side effects and get transactional semantics over a concurrent batch
+// Results from functions that finish after Map has returned are rolled back as soon as they finish
+// The result type of rollback has to match the result type of the Map function, otherwise an error is returned
+func WithRollback[RET any](rollback func(RET)) MapSetting {
+	return func(mo *mapOptions) {
+		mo.rollback = rollback
+	}
+}
+
+// WithDiscardOnCancel guarantees that no results are returned if Map is cancelled, even if other settings
+// would return partial results, and calls cleanup with each result that was produced, including the ones that
+// are produced after Map has returned
+// The result type of cleanup has to match the result type of the Map function, otherwise an error is returned
+func WithDiscardOnCancel[RET any](cleanup func(RET)) MapSetting {
+	return func(mo *mapOptions) {
+		mo.discardOnCancel = cleanup
+	}
+}
+
+// WithResultSampler calls sink with a random sample of the successful results, where each result has
+// the probability rate of being sampled. This is useful for spot-checking large batches
+// sink is called from the workers, so it has to be safe for concurrent use
+// The result type of sink has to match the result type of the Map function, otherwise an error is returned
+func WithResultSampler[RET any](rate float64, sink func(index int, r RET)) MapSetting {
+	return func(mo *mapOptions) {
+		mo.sampleRate = rate
+		mo.sampleSink = sink
+	}
+}
+
 // Map takes a slice and a function, it then calls the function with each value of the slice
 // The return of each function will be values in the returned slice
+// A result is only written to the returned slice once the function has returned successfully, so a value
+// that made the function panic or return an error is always left as the zero value, never partially written
 func Map[TYPE any, RET any](
 	ss []TYPE,
 	fn func(TYPE) (RET, error),
 	settings ...MapSetting,
 ) ([]RET, error) {
+	return MapRange(ss, 0, len(ss), fn, settings...)
+}
+
+// MapRange works like Map, but only calls the function with the values of ss[start:end]
+// The returned slice has the same length as ss, with only the values in the range populated, which
+// means that the indexes of the results always match the input. This makes it possible to share
+// one input slice between multiple MapRange calls (e.g. when sharding work) without keeping track of offsets
+func MapRange[TYPE any, RET any](
+	ss []TYPE,
+	start, end int,
+	fn func(TYPE) (RET, error),
+	settings ...MapSetting,
+) ([]RET, error) {
+	return mapRangeIndexed(ss, start, end, func(_ int, v TYPE) (RET, error) {
+		return fn(v)
+	}, settings)
+}
+
+// MapCtx works like Map, but the function is also called with a context that is cancelled when Map returns,
+// for example because of an error, the context being cancelled or a timeout. This makes it possible for the
+// function to abort in-flight work, instead of Map having to leave it running
+func MapCtx[TYPE any, RET any](
+	ss []TYPE,
+	fn func(ctx context.Context, v TYPE) (RET, error),
+	settings ...MapSetting,
+) ([]RET, error) {
+	var ctx context.Context
+	settings = append(settings[:len(settings):len(settings)], WithDoneContext(&ctx))
+	return Map(ss, func(v TYPE) (RET, error) {
+		return fn(ctx, v)
+	}, settings...)
+}
+
+// MapIndexed works like Map, but the function is also called with the index of the value in the slice
+func MapIndexed[TYPE any, RET any](
+	ss []TYPE,
+	fn func(i int, v TYPE) (RET, error),
+	settings ...MapSetting,
+) ([]RET, error) {
+	return mapRangeIndexed(ss, 0, len(ss), fn, settings)
+}
+
+// MapSubset works like Map, but only calls the function with the values of all at the given indices
+// The results are returned keyed by the index of the value in all
+// Settings that operate on the result slice are not supported, except WithInputSnapshot
+func MapSubset[TYPE any, RET any](
+	all []TYPE,
+	indices []int,
+	fn func(TYPE) (RET, error),
+	settings ...MapSetting,
+) (map[int]RET, error) {
+	for _, i := range indices {
+		if i < 0 || i >= len(all) {
+			return nil, fmt.Errorf("index %d out of range of slice with length %d", i, len(all))
+		}
+	}
+	all, settings, err := indexedInput("MapSubset", all, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := Map(indices, func(i int) (RET, error) {
+		return fn(all[i])
+	}, settings...)
+	if err != nil {
+		return nil, err
+	}
+
+	keyed := make(map[int]RET, len(indices))
+	for j, i := range indices {
+		keyed[i] = ret[j]
+	}
+	return keyed, nil
+}
+
+// mapRangeIndexed is the implementation of MapRange and MapIndexed
+func mapRangeIndexed[TYPE any, RET any](
+	ss []TYPE,
+	start, end int,
+	fn func(i int, v TYPE) (RET, error),
+	settings []MapSetting,
+) ([]RET, error) {
+	if start < 0 || end > len(ss) || start > end {
+		return nil, fmt.Errorf("invalid range [%d:%d] of slice with length %d", start, end, len(ss))
+	}
+
+	options, err := newMapOptions(end-start, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	queue, err := typedSetting[*WorkQueue[TYPE]](options.workQueue, "work queue")
+	if err != nil {
+		return nil, err
+	}
+	// The total number of values is not known up front with a work queue
+	if queue != nil && options.checkpoint != nil {
+		return nil, errors.New("WithCheckpoint can't be used together with WithWorkQueue")
+	}
+	if queue != nil && options.progress != nil {
+		return nil, errors.New("WithProgress can't be used together with WithWorkQueue")
+	}
+	// Each round of the work queue is a separate run, so settings that count the values across the whole call
+	// would start over every round, while the timeout is shared by the rounds instead
+	if queue != nil {
+		for _, setting := range options.countingSettings() {
+			if setting.used {
+				return nil, fmt.Errorf("%s can't be used together with WithWorkQueue", setting.name)
+			}
+		}
+		if options.timeout > 0 {
+			ctx, cancel := context.WithTimeout(options.ctx, options.timeout)
+			defer cancel()
+			settings = append(settings[:len(settings):len(settings)], WithContext(ctx), WithTimeout(0))
+			options.ctx, options.timeout = ctx, 0
+		}
+	}
+
+	// With compaction, the errors of the failed values are collected, and the values removed from the result
+	failures := compactedErrors{}
+	compacted := func(err error) error {
+		var c compactedErrors
+		if !errors.As(err, &c) {
+			return err
+		}
+		maps.Copy(failures, c)
+		return nil
+	}
+
+	ret, err := mapRange(ss[start:end], start, fn, options)
+	if ret != nil && end-start < len(ss) {
+		full := make([]RET, len(ss))
+		copy(full[start:], ret)
+		ret = full
+	}
+	if err := compacted(err); err != nil {
+		return ret, err
+	}
+
+	// Values added to the work queue are processed in rounds, until no more values are added
+	if queue != nil {
+		for items := queue.take(); len(items) > 0; items = queue.take() {
+			options, err := newMapOptions(len(items), settings)
+			if err != nil {
+				return nil, err
+			}
+			// The values added to the queue are indexed after all previous values
+			queueRet, err := mapRange(items, len(ret), fn, options)
+			if err := compacted(err); err != nil {
+				return nil, err
+			}
+			ret = append(ret, queueRet...)
+		}
+	}
+
+	if len(failures) > 0 {
+		compacted := make([]RET, 0, len(ret)-len(failures))
+		var errs []error
+		for i, r := range ret {
+			if err, failed := failures[i]; failed {
+				errs = append(errs, err)
+				continue
+			}
+			compacted = append(compacted, r)
+		}
+		ret, err = postProcess(compacted, options)
+		if err != nil {
+			return nil, err
+		}
+		return ret, errors.Join(errs...)
+	}
+
+	return postProcess(ret, options)
+}
+
+// mapRange calls the function with the values of items, with all settings except the ones that operates on the
+// whole result slice. The values are indexed from offset, so that indexes are unique across multiple calls
+// The returned slice has the same length as items
+func mapRange[TYPE any, RET any](
+	items []TYPE,
+	offset int,
+	fn func(i int, v TYPE) (RET, error),
+	options mapOptions,
+) ([]RET, error) {
+	validate, err := typedSetting[func(int, RET) error](options.resultValidator, "result validator")
+	if err != nil {
+		return nil, err
+	}
+
+	intern, err := typedSetting[func(RET) RET](options.interner, "interner")
+	if err != nil {
+		return nil, err
+	}
+	internLock := sync.Mutex{}
+
+	rollback, err := typedSetting[func(RET)](options.rollback, "rollback")
+	if err != nil {
+		return nil, err
+	}
+
+	cleanupOnCancel, err := typedSetting[func(RET)](options.discardOnCancel, "discard on cancel cleanup")
+	if err != nil {
+		return nil, err
+	}
+
+	sample, err := typedSetting[func(int, RET)](options.sampleSink, "result sampler")
+	if err != nil {
+		return nil, err
+	}
+
+	onResult, err := typedSetting[func(int, RET)](options.onResult, "result callback")
+	if err != nil {
+		return nil, err
+	}
+	onResultLock := sync.Mutex{}
+
+	input := items
+	if options.inputSnapshot {
+		input = append([]TYPE(nil), input...)
+	}
+
+	skip := make(map[int]bool, len(options.resumeFrom))
+	for _, i := range options.resumeFrom {
+		skip[i] = true
+	}
+
+	ret := newResults[RET](len(items))
+	if options.assertOrdering {
+		ret.countWrites()
+	}
+
+	if options.checkpoint != nil {
+		checkpoint := func() {
+			completed := ret.completed()
+			for j := range completed {
+				completed[j] += offset
+			}
+			for _, i := range options.resumeFrom {
+				if i >= offset && i < offset+len(items) {
+					completed = append(completed, i)
+				}
+			}
+			sort.Ints(completed)
+			options.checkpoint(completed)
+		}
+
+		checkpointStop := make(chan struct{})
+		checkpointDone := make(chan struct{})
+		go func() {
+			defer close(checkpointDone)
+			ticker := time.NewTicker(options.checkpointInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-checkpointStop:
+					return
+				case <-ticker.C:
+					checkpoint()
+				}
+			}
+		}()
+		defer func() {
+			close(checkpointStop)
+			<-checkpointDone
+			checkpoint()
+		}()
+	}
+
+	err = run(input, offset, func(i int, v TYPE) error {
+		if skip[i] {
+			return nil
+		}
+
+		r, err := fn(i, v)
+		if err != nil {
+			return err
+		}
+		if options.warnOnZeroResult != nil && reflect.ValueOf(&r).Elem().IsZero() {
+			options.warnOnZeroResult(i)
+		}
+		if validate != nil {
+			if err := validate(i, r); err != nil {
+				return err
+			}
+		}
+		if intern != nil {
+			internLock.Lock()
+			r = intern(r)
+			internLock.Unlock()
+		}
+		if err := ret.setValue(i-offset, r); err != nil {
+			return err
+		}
+		// The top-level functions of math/rand does not share a lock between go-routines
+		if sample != nil && rand.Float64() < options.sampleRate {
+			sample(i, r)
+		}
+		if onResult != nil {
+			onResultLock.Lock()
+			onResult(i, r)
+			onResultLock.Unlock()
+		}
+		return nil
+	}, options)
+	// The failed values are removed from the results by the caller
+	var compacted compactedErrors
+	if errors.As(err, &compacted) {
+		return ret.seal(), err
+	}
+	if err != nil {
+		var discard []func(RET)
+		if rollback != nil {
+			discard = append(discard, rollback)
+		}
+		if cleanupOnCancel != nil && isCancellation(err) {
+			discard = append(discard, cleanupOnCancel)
+		}
+		if len(discard) > 0 {
+			ret.sealAndDiscard(func(r RET) {
+				for _, d := range discard {
+					d(r)
+				}
+			})
+		}
+
+		if cleanupOnCancel == nil && options.completeInFlightOnCancel > 0 && isCancellation(err) {
+			return ret.seal(), err
+		}
+		if options.partialResults && len(discard) == 0 {
+			return ret.seal(), err
+		}
+		return nil, err
+	}
+
+	return ret.seal(), nil
+}
+
+// newMapOptions creates the options from the default values and the settings, for a run of size elements
+func newMapOptions(size int, settings []MapSetting) (mapOptions, error) {
 	options := mapOptions{
-		maxConcurrency: len(ss),
+		maxConcurrency: size,
 		ctx:            context.Background(),
 	}
 	for _, setting := range settings {
 		setting(&options)
 	}
 
+	// A max concurrency of 0 means the default
+	if options.maxConcurrency == 0 {
+		options.maxConcurrency = size
+	}
+
 	// Sanity checks
-	if options.maxConcurrency > len(ss) {
-		options.maxConcurrency = len(ss)
+	if options.warmupStart > 0 && (options.warmupStart > options.maxConcurrency || options.warmupStep < 1 || options.warmupInterval <= 0) {
+		return options, fmt.Errorf("invalid concurrency warmup from %d to %d with step %d every %s",
+			options.warmupStart, options.maxConcurrency, options.warmupStep, options.warmupInterval)
+	}
+	if options.failureThreshold != nil && (*options.failureThreshold < 0 || *options.failureThreshold > 1) {
+		return options, fmt.Errorf("failure threshold has to be between 0 and 1, was %g", *options.failureThreshold)
+	}
+	if options.hostFn != nil && options.perHost < 1 {
+		return options, fmt.Errorf("concurrency per host can't be less than 1, was %d", options.perHost)
+	}
+	if options.maxErrors < 0 {
+		return options, fmt.Errorf("max errors can't be negative, was %d", options.maxErrors)
+	}
+	if options.prefetch < 0 {
+		return options, fmt.Errorf("prefetch can't be negative, was %d", options.prefetch)
+	}
+	if options.rampDownInterval < 0 {
+		return options, fmt.Errorf("ramp down interval can't be negative, was %s", options.rampDownInterval)
+	}
+	if options.retryAttempts < 0 {
+		return options, fmt.Errorf("retry attempts can't be negative, was %d", options.retryAttempts)
+	}
+	if options.backoffBase < 0 || (options.backoffBase > 0 && options.backoffFactor < 1) {
+		return options, fmt.Errorf("invalid backoff with base %s and factor %g", options.backoffBase, options.backoffFactor)
+	}
+	if options.rateLimit < 0 || (options.rateLimit > 0 && options.ratePer <= 0) {
+		return options, fmt.Errorf("invalid rate limit of %d per %s", options.rateLimit, options.ratePer)
+	}
+	if options.doneBuffer != nil && *options.doneBuffer < 0 {
+		return options, fmt.Errorf("done buffer can't be negative, was %d", *options.doneBuffer)
+	}
+	if options.scope != nil && options.maxConcurrency > options.scope.size {
+		options.maxConcurrency = options.scope.size
+	}
+	if options.maxConcurrency > size {
+		options.maxConcurrency = size
 	} else if options.maxConcurrency < 0 {
-		return nil, fmt.Errorf("maxConcurrency can't be less than 1, was %d", options.maxConcurrency)
+		return options, fmt.Errorf("maxConcurrency can't be negative, was %d", options.maxConcurrency)
+	}
+
+	return options, nil
+}
+
+// sleepCtx waits for the duration, and returns true, unless the context is done first
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isCancellation checks if the error is caused by a cancelled or timed out context
+func isCancellation(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// namedSetting is a setting that is not supported by every function, and whether it's used
+type namedSetting struct {
+	name string
+	used bool
+}
+
+// resultSettings are the settings that are handled by mapRange and mapRangeIndexed, and therefore only
+// supported by the functions that are built on them
+func (o mapOptions) resultSettings() []namedSetting {
+	return []namedSetting{
+		{"WithInputSnapshot", o.inputSnapshot},
+		{"WithResumeFrom", o.resumeFrom != nil},
+		{"WithCheckpoint", o.checkpoint != nil},
+		{"WithWorkQueue", o.workQueue != nil},
+		{"WithCompactSuccessful", o.compactResults},
+		{"WithPartialResults", o.partialResults},
+		{"WithAssertOrdering", o.assertOrdering},
+		{"WithWarnOnZeroResults", o.warnOnZeroResult != nil},
+		{"WithResultValidator", o.resultValidator != nil},
+		{"WithInterner", o.interner != nil},
+		{"WithRollback", o.rollback != nil},
+		{"WithDiscardOnCancel", o.discardOnCancel != nil},
+		{"WithResultSampler", o.sampleSink != nil},
+		{"WithResultDedupe", o.dedupe != nil},
 	}
+}
+
+// continueSettings are the settings that keep the run going after a value has failed, and can end it without an
+// error. They are not supported by the functions that commit the results in order, since no later results can be
+// committed past the gap that the failed value leaves
+func (o mapOptions) continueSettings() []namedSetting {
+	return []namedSetting{
+		{"WithContinueOnError", o.continueOnError},
+		{"WithFailureThreshold", o.failureThreshold != nil},
+		{"WithCircuitBreaker", o.circuitBreaker > 0},
+	}
+}
+
+// countingSettings are the settings that count the failed or successful values of a run
+func (o mapOptions) countingSettings() []namedSetting {
+	return append(o.continueSettings(),
+		namedSetting{"WithMaxErrors", o.maxErrors > 0},
+		namedSetting{"WithCancelAfterSuccesses", o.cancelAfterSuccesses > 0},
+	)
+}
+
+// unsupportedSettings returns an error for the first of the settings that is used
+func unsupportedSettings(fnName string, settings ...[]namedSetting) error {
+	for _, group := range settings {
+		for _, setting := range group {
+			if setting.used {
+				return fmt.Errorf("%s is not supported by %s", setting.name, fnName)
+			}
+		}
+	}
+	return nil
+}
+
+// typedSetting converts a setting stored as any to its real type, which can't be verified at
+// compile time since settings are not aware of the input and result types
+func typedSetting[T any](setting any, name string) (T, error) {
+	var typed T
+	if setting == nil {
+		return typed, nil
+	}
+	typed, ok := setting.(T)
+	if !ok {
+		return typed, fmt.Errorf("%s expected to be of type %T, was %T", name, typed, setting)
+	}
+	return typed, nil
+}
+
+// postProcess applies the settings that operates on the whole result slice, after all values are processed
+func postProcess[RET any](ret []RET, options mapOptions) ([]RET, error) {
+	if options.dedupe != nil {
+		deduped, err := options.dedupe(ret)
+		if err != nil {
+			return nil, err
+		}
+		ret = deduped.([]RET)
+	}
+
+	return ret, nil
+}
+
+// run calls fn with each value of items and its index, counted from offset, using the worker pool described by the options
+// The first error returned by fn (or a panic within it) is returned, and stops any new calls from being made
+func run[TYPE any](items []TYPE, offset int, fn func(i int, v TYPE) error, options mapOptions) (err error) {
+	start, end := offset, offset+len(items)
+	size := len(items)
 
 	// Setting up errors, so that new errors can be listened on with errChan, and they can be
 	// set by calling `setErr(err)` any number of times, but the first one will only be used
+	// The channel is never closed, since workers might still set an error after run has returned
 	errChan := make(chan error, 1)
 	errOnce := &sync.Once{}
+	// stopAcquire stops the wait for a free slot to dispatch the next value to, it's set once the slots are created
+	stopAcquire := func() {}
 	setErr := func(err error) {
 		errOnce.Do(func() {
 			errChan <- err
 		})
+		stopAcquire()
+	}
+
+	// The values of a slice are all available up front, so there is nothing to prefetch
+	if options.prefetch > 0 {
+		return errors.New("WithPrefetch is only supported by MapSeq and MapChanOrdered")
 	}
-	defer close(errChan)
 
-	// processingIndex is channel with the number
-	processingIndex := make(chan int, options.maxConcurrency)
+	runner, err := newValueRunner[TYPE](options, size, setErr)
+	if err != nil {
+		return err
+	}
+	logFinished := runner.logStarted()
+	defer func() { logFinished(err) }()
+
+	// processingIndex hands the indexes to the workers, while the number of values being processed at the same time
+	// is limited by slots. Every dispatched value holds a slot until it's done
+	processingIndex := make(chan int)
 	defer close(processingIndex)
 
-	wgDone, wgWait, wgStop := chanWaitGroup(len(ss))
+	// With a partitioner, every worker gets its own channel instead of sharing processingIndex
+	var partitionIndex []chan int
+	if options.partitioner != nil {
+		partitionIndex = make([]chan int, options.maxConcurrency)
+		for i := range partitionIndex {
+			partitionIndex[i] = make(chan int, 1)
+		}
+		defer func() {
+			for _, ch := range partitionIndex {
+				close(ch)
+			}
+		}()
+	}
+
+	doneBuffer := size
+	if options.doneBuffer != nil {
+		doneBuffer = *options.doneBuffer
+	}
+	wgDone, wgWait, wgStop := chanWaitGroup(size, doneBuffer)
 	defer wgStop()
 
-	ctx, _ := context.WithCancel(options.ctx)
+	ctx, cancel := context.WithCancel(options.ctx)
+	defer cancel()
+
+	if options.timeout > 0 {
+		var timeoutCancel context.CancelFunc
+		ctx, timeoutCancel = context.WithTimeout(ctx, options.timeout)
+		defer timeoutCancel()
+	}
+
+	if len(options.doneCtxs) > 0 {
+		doneCtx, doneCancel := context.WithCancel(ctx)
+		defer doneCancel()
+		for _, c := range options.doneCtxs {
+			*c = doneCtx
+		}
+	}
+
+	// The wait group would never be done with zero elements
+	if size == 0 {
+		return nil
+	}
+
+	slots, err := NewSemaphore(options.maxConcurrency)
+	if err != nil {
+		return err
+	}
+	acquireCtx, cancelAcquire := context.WithCancel(ctx)
+	defer cancelAcquire()
+	stopAcquire = cancelAcquire
+	runner.onEnough = cancelAcquire
+
+	if options.maxGoroutines > 0 {
+		if running := runtime.NumGoroutine(); running+options.maxConcurrency > options.maxGoroutines {
+			return fmt.Errorf("%w: starting %d workers with %d go-routines running would exceed the limit of %d",
+				ErrGoroutineBudgetExceeded, options.maxConcurrency, running, options.maxGoroutines)
+		}
+	}
+
+	if options.scope != nil {
+		if err := options.scope.reserve(ctx, options.maxConcurrency); err != nil {
+			return err
+		}
+	}
+
+	// Keep track of the values being processed, if in-flight values should be allowed to finish on cancellation
+	var inFlight sync.WaitGroup
+	inFlightLock := sync.Mutex{}
+	stopped := false
+	startProcessing := func() bool {
+		if options.completeInFlightOnCancel <= 0 {
+			return true
+		}
+		inFlightLock.Lock()
+		defer inFlightLock.Unlock()
+		if stopped {
+			return false
+		}
+		inFlight.Add(1)
+		return true
+	}
+	finishProcessing := func() {
+		if options.completeInFlightOnCancel > 0 {
+			inFlight.Done()
+		}
+	}
+
+	// cancelled should be called when the context is cancelled, to give in-flight values time to finish
+	cancelled := func() error {
+		if options.completeInFlightOnCancel > 0 {
+			inFlightLock.Lock()
+			stopped = true
+			inFlightLock.Unlock()
+
+			inFlightDone := make(chan struct{})
+			go func() {
+				inFlight.Wait()
+				close(inFlightDone)
+			}()
+
+			timer := time.NewTimer(options.completeInFlightOnCancel)
+			defer timer.Stop()
+			select {
+			case <-inFlightDone:
+			case <-timer.C:
+			}
+		}
+		return ctx.Err()
+	}
+	// failed returns how to shut down after the error. An error caused by the context being cancelled, like from
+	// waiting for the rate limiter, is handled in the same way as the cancellation itself
+	failed := func(err error) func() error {
+		if ctx.Err() != nil && isCancellation(err) {
+			return cancelled
+		}
+		return func() error { return err }
+	}
 
-	ret := make([]RET, len(ss))
+	// With a ramp-down, no more values are dispatched when the run is shut down, and the workers are stopped one at a
+	// time, in the order they were started, after finishing the value they are processing. The shutdown clears errs
+	// and done, to not be shut down again, and shutdownErr returns the error to return once rampedDown is closed,
+	// when all workers are stopped
+	var stages []chan struct{}
+	if options.rampDownInterval > 0 {
+		stages = make([]chan struct{}, options.maxConcurrency)
+		for i := range stages {
+			stages[i] = make(chan struct{})
+		}
+	}
+	var shuttingDown int32
+	var rampedDown <-chan struct{}
+	var shutdownErr func() error
+	errs, done := (<-chan error)(errChan), ctx.Done()
+	rampDownStop := make(chan struct{})
+	defer close(rampDownStop)
+	shutdown := func(err func() error) (error, bool) {
+		if options.rampDownInterval <= 0 {
+			return err(), true
+		}
+		shutdownErr = err
+		errs, done = nil, nil
+		atomic.StoreInt32(&shuttingDown, 1)
 
-	// Start up worked go-routines that will read from the work-pool and run the function with the value grabbed
-	for i := 0; i < options.maxConcurrency; i++ {
+		stopped := make(chan struct{})
+		rampedDown = stopped
 		go func() {
+			defer close(stopped)
+			ticker := time.NewTicker(options.rampDownInterval)
+			defer ticker.Stop()
+			for worker, stage := range stages {
+				close(stage)
+				if worker == len(stages)-1 {
+					return
+				}
+				select {
+				case <-rampDownStop:
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+		return nil, false
+	}
+	// enoughErr is returned when enough values have succeeded, unless the run was already shut down
+	enoughErr := func() error {
+		if shutdownErr != nil {
+			return shutdownErr()
+		}
+		return nil
+	}
+
+	// startWorker starts a worker go-routine that will read from the work-pool and run the function with the value grabbed
+	startWorker := func(worker int) {
+		labels := make([]string, 0, 2+2*len(options.pprofLabels))
+		for k, v := range options.pprofLabels {
+			labels = append(labels, k, v)
+		}
+		labels = append(labels, "conc_worker", strconv.Itoa(worker))
+
+		workerIndex := processingIndex
+		if partitionIndex != nil {
+			workerIndex = partitionIndex[worker]
+		}
+		// stage is closed when the worker is stopped by a ramp-down
+		var stage chan struct{}
+		if stages != nil {
+			stage = stages[worker]
+		}
+
+		spawn := func(worker func()) { go worker() }
+		if options.scope != nil {
+			spawn = options.scope.spawn
+		}
+
+		spawn(func() {
+			if options.lockOSThread {
+				runtime.LockOSThread()
+				defer runtime.UnlockOSThread()
+			}
+
+			// current is the index being processed, to know which value a panic belongs to
+			current := -1
 			defer func() {
 				if err := recover(); err != nil {
-					setErr(fmt.Errorf("panic: %v", err))
+					runner.panicked(err, current, worker)
 					wgDone()
+					slots.Release()
 				}
 			}()
 
-			// Fetch data from the data channel until nothing is left
-			for i := range processingIndex {
-				r, err := fn(ss[i])
-				if err != nil {
-					setErr(err)
-				} else {
-					ret[i] = r
+			pprof.Do(ctx, pprof.Labels(labels...), func(context.Context) {
+				// Fetch data from the data channel until nothing is left
+				for {
+					var i int
+					select {
+					case next, ok := <-workerIndex:
+						if !ok {
+							return
+						}
+						i = next
+					case <-stage:
+						return
+					}
+
+					// Stop as soon as the run is cancelled, instead of processing the values left in the channel
+					// With a ramp-down, the values left are skipped instead, while the worker waits to be stopped
+					if ctx.Err() != nil || atomic.LoadInt32(&shuttingDown) != 0 {
+						slots.Release()
+						if options.rampDownInterval > 0 {
+							continue
+						}
+						return
+					}
+					current = i
+					func() {
+						if !startProcessing() {
+							return
+						}
+						defer finishProcessing()
+
+						runner.process(ctx, i, items[i-start], worker, fn)
+					}()
+					wgDone()
+					slots.Release()
+				}
+			})
+		})
+	}
+
+	workers := options.maxConcurrency
+	if options.warmupStart > 0 && options.warmupStart < workers {
+		workers = options.warmupStart
+	}
+	for i := 0; i < workers; i++ {
+		startWorker(i)
+	}
+
+	// Start the rest of the workers gradually, if warmup is used
+	if workers < options.maxConcurrency {
+		// Wait for the warmup to stop before returning, so that no workers are spawned after the call has returned
+		warmupStop := make(chan struct{})
+		warmupDone := make(chan struct{})
+		defer func() {
+			close(warmupStop)
+			<-warmupDone
+		}()
+		go func() {
+			defer close(warmupDone)
+			ticker := time.NewTicker(options.warmupInterval)
+			defer ticker.Stop()
+			started := workers
+			if options.scope != nil {
+				defer func() { options.scope.release(options.maxConcurrency - started) }()
+			}
+			for started < options.maxConcurrency {
+				select {
+				case <-warmupStop:
+					return
+				case <-ticker.C:
+				}
+				for i := 0; i < options.warmupStep && started < options.maxConcurrency; i++ {
+					startWorker(started)
+					started++
 				}
-				wgDone()
 			}
 		}()
 	}
 
 	// Loop through all elements and put them into the queue, while
-	for i := range ss {
-		select {
-		case err := <-errChan:
-			return nil, err
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case processingIndex <- i:
-			// Job processed, continue to the next index
+dispatching:
+	for i := start; i < end; i++ {
+		if options.precondition != nil {
+			ok, err := options.precondition(i)
+			if err != nil {
+				return err
+			} else if !ok {
+				// A skipped value is done right away, and counts towards the progress
+				runner.progress()
+				wgDone()
+				continue
+			}
+		}
+
+		dispatch := processingIndex
+		if partitionIndex != nil {
+			partition := options.partitioner(i, len(partitionIndex)) % len(partitionIndex)
+			if partition < 0 {
+				partition += len(partitionIndex)
+			}
+			dispatch = partitionIndex[partition]
+		}
+		// Without a free slot, the run has been stopped, and the value is never dispatched
+		if slots.Acquire(acquireCtx) != nil {
+			dispatch = nil
+		}
+
+		for dispatched := false; !dispatched; {
+			select {
+			case err := <-errs:
+				if err, ok := shutdown(failed(err)); ok {
+					return err
+				}
+				// Nothing more is dispatched during the ramp-down
+				break dispatching
+			case <-done:
+				if err, ok := shutdown(cancelled); ok {
+					return err
+				}
+				break dispatching
+			case <-rampedDown:
+				return shutdownErr()
+			case <-runner.enough:
+				return enoughErr()
+			case dispatch <- i:
+				// Job processed, continue to the next index
+				dispatched = true
+				runner.trace("dispatch", i, -1, nil)
+			}
 		}
 	}
 
 	// We now have started the last concurent go-routine
 
 	// Wait for either all the final go-routines to finish, an error, or context cancellation
-	select {
-	case err := <-errChan:
-		return nil, err
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case <-wgWait:
-		// Since select statements isn't deterministic, we need to ensure that no error was actually exist in the errChan
+	for {
 		select {
-		case err := <-errChan:
-			return nil, err
-		default:
+		case err := <-errs:
+			if err, ok := shutdown(failed(err)); ok {
+				return err
+			}
+		case <-done:
+			if err, ok := shutdown(cancelled); ok {
+				return err
+			}
+		case <-rampedDown:
+			return shutdownErr()
+		case <-runner.enough:
+			return enoughErr()
+		case <-wgWait:
+			if shutdownErr != nil {
+				return shutdownErr()
+			}
+			// Since select statements isn't deterministic, we need to ensure that no error was actually exist in the errChan
+			select {
+			case err := <-errChan:
+				return err
+			default:
+			}
+
+			return runner.result(size)
 		}
-		return ret, nil
 	}
 }
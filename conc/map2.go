@@ -0,0 +1,30 @@
+package conc
+
+// Map2 works like Map, but for functions that returns two results. The results are returned as two slices,
+// both in the same order as the input
+func Map2[TYPE any, RET1 any, RET2 any](
+	ss []TYPE,
+	fn func(TYPE) (RET1, RET2, error),
+	settings ...MapSetting,
+) ([]RET1, []RET2, error) {
+	type pair struct {
+		first  RET1
+		second RET2
+	}
+
+	pairs, err := Map(ss, func(v TYPE) (pair, error) {
+		first, second, err := fn(v)
+		return pair{first: first, second: second}, err
+	}, settings...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	firsts := make([]RET1, len(pairs))
+	seconds := make([]RET2, len(pairs))
+	for i, p := range pairs {
+		firsts[i] = p.first
+		seconds[i] = p.second
+	}
+	return firsts, seconds, nil
+}
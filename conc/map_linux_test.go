@@ -0,0 +1,58 @@
+package conc_test
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lindell/conc/conc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapLockOSThreadThreads(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	lock := sync.Mutex{}
+	// threads are the OS threads that each worker go-routine has run on
+	threads := map[int]map[int]bool{}
+	_, err := conc.Map(make([]int, 1000), func(v int) (int, error) {
+		before := syscall.Gettid()
+		// Park the go-routine, to give the scheduler a chance to resume it on another thread
+		time.Sleep(10 * time.Microsecond)
+		after := syscall.Gettid()
+
+		lock.Lock()
+		defer lock.Unlock()
+		id := goroutineID()
+		if threads[id] == nil {
+			threads[id] = map[int]bool{}
+		}
+		threads[id][before] = true
+		threads[id][after] = true
+		return v, nil
+	}, conc.WithMaxConcurrency(4), conc.WithLockOSThread())
+	assert.NoError(t, err)
+
+	// Every worker stays on its own thread
+	owners := map[int]int{}
+	for id, tids := range threads {
+		assert.Len(t, tids, 1, "go-routine %d ran on multiple threads", id)
+		for tid := range tids {
+			if owner, ok := owners[tid]; ok {
+				t.Errorf("thread %d was used by both go-routine %d and %d", tid, owner, id)
+			}
+			owners[tid] = id
+		}
+	}
+
+	// A thread that is still locked when its go-routine exits is terminated, while unlocked threads are kept
+	// Wait for the workers to exit before checking that their threads are still alive
+	time.Sleep(finishWait)
+	for tid := range owners {
+		_, err := os.Stat(fmt.Sprintf("/proc/self/task/%d", tid))
+		assert.NoError(t, err, "thread %d was terminated, so it was never unlocked", tid)
+	}
+}
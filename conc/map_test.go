@@ -1,13 +1,23 @@
 package conc_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
 	"os"
 	"runtime"
+	"runtime/pprof"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -69,6 +79,69 @@ func TestMapMaxConcurrency(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestMapMaxProcs(t *testing.T) {
+	defer checkGoRoutines(t)()
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	ints := make([]int, bigTestSize)
+	for i := 0; i < bigTestSize; i++ {
+		ints[i] = i
+	}
+	tests := []struct {
+		name       string
+		setting    conc.MapSetting
+		concurrent int
+	}{
+		{"max procs", conc.WithMaxProcs(), 4},
+		{"fraction", conc.WithMaxConcurrencyFraction(0.5), 2},
+		{"small fraction", conc.WithMaxConcurrencyFraction(0.1), 1},
+	}
+	for _, test := range tests {
+		latest := 0
+		lock := sync.Mutex{}
+		_, err := conc.Map(ints, func(v int) (string, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			if v > latest+test.concurrent {
+				t.Fatalf("%s jumped ahead more than the max concurrency", test.name)
+			} else if v > latest {
+				latest = v
+			}
+			return fmt.Sprint(v), nil
+		}, test.setting)
+		assert.NoError(t, err)
+	}
+}
+
+func TestMapMaxConcurrencyZero(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := 0; i < bigTestSize; i++ {
+		ints[i] = i
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ret, err := conc.Map(ints, func(v int) (int, error) {
+			return v * 2, nil
+		}, conc.WithMaxConcurrency(0))
+		assert.NoError(t, err)
+		assert.Len(t, ret, bigTestSize)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Map with a max concurrency of 0 did not finish")
+	}
+
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithMaxConcurrency(-1))
+	assert.Equal(t, errors.New("maxConcurrency can't be negative, was -1"), err)
+}
+
 func TestAllErrors(t *testing.T) {
 	defer checkGoRoutines(t)()
 
@@ -113,7 +186,23 @@ func TestMapPanic(t *testing.T) {
 	_, err := conc.Map(ints, func(v int) (int, error) {
 		return 1 / (v - 1), nil // Panics if the value is 1
 	}, conc.WithMaxConcurrency(10))
-	assert.Equal(t, errors.New("panic: runtime error: integer divide by zero"), err)
+	assert.EqualError(t, err, "panic: runtime error: integer divide by zero")
+
+	var panicErr *conc.PanicError
+	if assert.True(t, errors.As(err, &panicErr)) {
+		runtimeErr, ok := panicErr.Value.(runtime.Error)
+		if assert.True(t, ok) {
+			assert.Equal(t, "runtime error: integer divide by zero", runtimeErr.Error())
+		}
+		assert.Equal(t, bigTestSize-2, panicErr.Index)
+		assert.Contains(t, string(panicErr.Stack), "TestMapPanic")
+	}
+
+	_, err = conc.Map(ints, func(v int) (int, error) {
+		return 1 / (v - 1), nil
+	}, conc.WithMaxConcurrency(10), conc.WithFailOnWorkerLoss())
+	assert.ErrorIs(t, err, conc.ErrWorkerLost)
+	assert.True(t, errors.As(err, &panicErr))
 }
 
 func TestMapCancelContextEarly(t *testing.T) {
@@ -161,3 +250,3822 @@ func TestMapCancelContextLate(t *testing.T) {
 	assert.LessOrEqual(t, time.Since(beforeTime), timeLongestMap)
 	time.Sleep(timeLongestMap - timeBeforeCancel) // Make sure we don't leave any goroutines behind
 }
+
+func TestMapLockOSThread(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+	ret, err := conc.Map(ints, func(v int) (int, error) {
+		return v * 2, nil
+	}, conc.WithMaxConcurrency(4), conc.WithLockOSThread())
+	assert.NoError(t, err)
+	for i, v := range ret {
+		assert.Equal(t, i*2, v)
+	}
+}
+
+func TestMapResultDedupe(t *testing.T) {
+	ret, err := conc.Map([]string{"a.com", "b.com", "A.com", "c.com", "B.COM"}, func(v string) (string, error) {
+		return v, nil
+	}, conc.WithResultDedupe(strings.ToLower))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.com", "b.com", "c.com"}, ret)
+
+	_, err = conc.Map([]string{"6", "2"}, strconv.Atoi, conc.WithResultDedupe(strings.ToLower))
+	assert.Error(t, err)
+}
+
+func TestMapPprofLabels(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	_, err := conc.Map([]int{1}, func(v int) (int, error) {
+		buf := &bytes.Buffer{}
+		if err := pprof.Lookup("goroutine").WriteTo(buf, 1); err != nil {
+			return 0, err
+		}
+		if !strings.Contains(buf.String(), `"job":"labeltest"`) {
+			return 0, errors.New("missing custom label")
+		}
+		if !strings.Contains(buf.String(), `"conc_worker":"0"`) {
+			return 0, errors.New("missing worker label")
+		}
+		return v, nil
+	}, conc.WithPprofLabels(map[string]string{"job": "labeltest"}))
+	assert.NoError(t, err)
+}
+
+func TestMapCircuitBreaker(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	calls := int64(0)
+	ints := make([]int, bigTestSize)
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		return 0, errors.New("downstream unavailable")
+	}, conc.WithMaxConcurrency(1), conc.WithCircuitBreaker(5))
+
+	var circuitErr *conc.CircuitOpenError
+	assert.ErrorIs(t, err, conc.ErrCircuitOpen)
+	assert.ErrorAs(t, err, &circuitErr)
+	assert.Len(t, circuitErr.Errs, 5)
+	time.Sleep(finishWait)
+	assert.LessOrEqual(t, atomic.LoadInt64(&calls), int64(7))
+}
+
+func TestMapCircuitBreakerNotTripped(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	calls := int64(0)
+	_, err := conc.Map([]int{1, 0, 1, 0, 1, 1, 0}, func(v int) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		if v == 1 {
+			return 0, fmt.Errorf("error %d", atomic.LoadInt64(&calls))
+		}
+		return v, nil
+	}, conc.WithMaxConcurrency(1), conc.WithCircuitBreaker(3))
+	assert.Equal(t, errors.New("error 1"), err)
+	assert.Equal(t, int64(7), atomic.LoadInt64(&calls))
+}
+
+func TestMapRange(t *testing.T) {
+	ret, err := conc.MapRange([]string{"6", "2", "1", "76", "3"}, 1, 4, strconv.Atoi)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 2, 1, 76, 0}, ret)
+
+	ret, err = conc.MapRange([]string{"6", "2"}, 1, 1, strconv.Atoi)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 0}, ret)
+
+	_, err = conc.MapRange([]string{"6", "2"}, 1, 3, strconv.Atoi)
+	assert.Equal(t, errors.New("invalid range [1:3] of slice with length 2"), err)
+
+	_, err = conc.MapRange([]string{"6", "2"}, 2, 1, strconv.Atoi)
+	assert.Error(t, err)
+
+	_, err = conc.MapRange([]string{"6", "2"}, -1, 1, strconv.Atoi)
+	assert.Error(t, err)
+}
+
+func TestMapDoneContext(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	var doneCtx context.Context
+	_, err := conc.Map([]int{1, 2, 3}, func(v int) (int, error) {
+		if doneCtx.Err() != nil {
+			return 0, errors.New("done context cancelled during the run")
+		}
+		return v, nil
+	}, conc.WithDoneContext(&doneCtx))
+	assert.NoError(t, err)
+	assert.Equal(t, context.Canceled, doneCtx.Err())
+
+	_, err = conc.Map([]int{1, 2, 3}, func(v int) (int, error) {
+		return 0, errors.New("test error")
+	}, conc.WithDoneContext(&doneCtx))
+	assert.Error(t, err)
+	assert.Equal(t, context.Canceled, doneCtx.Err())
+
+	_, err = conc.Map([]string{}, strconv.Atoi, conc.WithDoneContext(&doneCtx))
+	assert.NoError(t, err)
+	assert.Equal(t, context.Canceled, doneCtx.Err())
+}
+
+func TestMapInputSnapshot(t *testing.T) {
+	ints := []int{1, 2, 3, 4, 5}
+	ret, err := conc.Map(ints, func(v int) (int, error) {
+		if v == 1 {
+			for i := range ints {
+				ints[i] = 0
+			}
+		}
+		return v * 10, nil
+	}, conc.WithMaxConcurrency(1), conc.WithInputSnapshot())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10, 20, 30, 40, 50}, ret)
+
+	// The functions that return values of the input return the values from the snapshot
+	ints = []int{1, 2, 3, 4, 5}
+	zeroInput := func(v int) {
+		if v == 1 {
+			for i := range ints {
+				ints[i] = 0
+			}
+		}
+	}
+	filtered, err := conc.Filter(ints, func(v int) (bool, error) {
+		zeroInput(v)
+		return v%2 == 1, nil
+	}, conc.WithMaxConcurrency(1), conc.WithInputSnapshot())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 3, 5}, filtered)
+
+	ints = []int{1, 2, 3, 4, 5}
+	matched, unmatched, err := conc.Partition(ints, func(v int) (bool, error) {
+		zeroInput(v)
+		return v%2 == 1, nil
+	}, conc.WithMaxConcurrency(1), conc.WithInputSnapshot())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 3, 5}, matched)
+	assert.Equal(t, []int{2, 4}, unmatched)
+
+	ints = []int{1, 2, 3, 4, 5}
+	groups, err := conc.GroupBy(ints, func(v int) (int, error) {
+		zeroInput(v)
+		return v % 2, nil
+	}, conc.WithMaxConcurrency(1), conc.WithInputSnapshot())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int][]int{0: {2, 4}, 1: {1, 3, 5}}, groups)
+
+	ints = []int{1, 2, 3, 4, 5}
+	value, index, found, err := conc.Find(ints, func(v int) (bool, error) {
+		zeroInput(v)
+		return v == 4, nil
+	}, conc.WithMaxConcurrency(1), conc.WithInputSnapshot())
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 3, index)
+	assert.Equal(t, 4, value)
+}
+
+func TestMapResultValidator(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	validator := conc.WithResultValidator(func(index int, r int) error {
+		if r < 0 {
+			return fmt.Errorf("negative result at index %d", index)
+		}
+		return nil
+	})
+
+	ret, err := conc.Map([]string{"6", "2", "1"}, strconv.Atoi, validator)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{6, 2, 1}, ret)
+
+	_, err = conc.Map([]string{"6", "-2", "1"}, strconv.Atoi, validator)
+	assert.Equal(t, errors.New("negative result at index 1"), err)
+
+	_, err = conc.Map([]string{"6"}, func(v string) (string, error) {
+		return v, nil
+	}, validator)
+	assert.Equal(t, errors.New("result validator expected to be of type func(int, string) error, was func(int, int) error"), err)
+}
+
+func TestMapCompleteInFlightOnCancel(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	ints := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	ret, err := conc.Map(ints, func(v int) (int, error) {
+		time.Sleep(50 * time.Millisecond)
+		return v * 10, nil
+	}, conc.WithMaxConcurrency(3), conc.WithContext(ctx), conc.WithCompleteInFlightOnCancel(time.Second))
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, []int{10, 20, 30, 0, 0, 0, 0, 0, 0, 0}, ret)
+
+	// The values waiting for their host when the context is cancelled fail with the cancellation, which still waits
+	// for the value that is running
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	ret, err = conc.Map(ints, func(v int) (int, error) {
+		time.Sleep(50 * time.Millisecond)
+		return v * 10, nil
+	}, conc.WithMaxConcurrency(3), conc.WithContext(ctx), conc.WithCompleteInFlightOnCancel(time.Second),
+		conc.WithConcurrencyPerHost(1, func(int) string { return "example.com" }))
+	assert.Equal(t, context.Canceled, err)
+	completed := 0
+	for _, r := range ret {
+		if r != 0 {
+			completed++
+		}
+	}
+	assert.Equal(t, 1, completed, "only the running value should have a result, got %v", ret)
+}
+
+func TestMapCompleteInFlightOnCancelTimeout(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	before := time.Now()
+	ret, err := conc.Map([]int{1, 2}, func(v int) (int, error) {
+		if v == 2 {
+			time.Sleep(finishWait / 2)
+		}
+		return v * 10, nil
+	}, conc.WithContext(ctx), conc.WithCompleteInFlightOnCancel(20*time.Millisecond))
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, []int{10, 0}, ret)
+	assert.Less(t, time.Since(before), finishWait/2)
+}
+
+func TestMapRampDownOnShutdown(t *testing.T) {
+	const workers = 4
+	const interval = 50 * time.Millisecond
+	// slack is the time a stopped worker might still be finishing its last value
+	const slack = 10 * time.Millisecond
+
+	for _, test := range []struct {
+		name     string
+		shutdown func(cancel context.CancelFunc) error
+		err      error
+	}{
+		{"cancel", func(cancel context.CancelFunc) error { cancel(); return nil }, context.Canceled},
+		{"error", func(context.CancelFunc) error { return errors.New("test error") }, errors.New("test error")},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			defer checkGoRoutines(t)()
+
+			// The workers run in a scope, where each stopped worker frees up a go-routine for other calls
+			scope, err := conc.NewScope(workers)
+			assert.NoError(t, err)
+			defer scope.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			lock := sync.Mutex{}
+			var shutdown time.Time
+			var running, startedAfter int64
+			// freed is the time after the shutdown when each number of go-routines could be used by another call
+			freed := make([]time.Duration, workers)
+			probed := make(chan struct{})
+
+			_, err = conc.Map(make([]int, 10000), func(int) (int, error) {
+				n := atomic.AddInt64(&running, 1)
+				defer atomic.AddInt64(&running, -1)
+
+				lock.Lock()
+				first := shutdown.IsZero() && n == workers
+				if first {
+					shutdown = time.Now()
+				} else if !shutdown.IsZero() && time.Since(shutdown) > slack {
+					startedAfter++
+				}
+				lock.Unlock()
+
+				if first {
+					go func() {
+						defer close(probed)
+						for n := 1; n <= workers; n++ {
+							_, err := conc.Map(make([]int, n), func(v int) (int, error) { return v, nil },
+								conc.WithScope(scope), conc.WithMaxConcurrency(n))
+							assert.NoError(t, err)
+							freed[n-1] = time.Since(shutdown)
+						}
+					}()
+					return 0, test.shutdown(cancel)
+				}
+				time.Sleep(time.Millisecond)
+				return 0, nil
+			}, conc.WithMaxConcurrency(workers), conc.WithContext(ctx), conc.WithScope(scope),
+				conc.WithRampDownOnShutdown(interval))
+			assert.Equal(t, test.err, err)
+			<-probed
+
+			lock.Lock()
+			defer lock.Unlock()
+			assert.GreaterOrEqual(t, time.Since(shutdown), (workers-1)*interval)
+			assert.Zero(t, startedAfter, "no values should be started after the shutdown")
+			// One more worker is stopped every interval, starting with one right away
+			for n, d := range freed {
+				stopped := time.Duration(n) * interval
+				assert.True(t, d >= stopped-slack && d < stopped+interval-slack,
+					"%d go-routines were freed after %s, expected after %s", n+1, d, stopped)
+			}
+		})
+	}
+
+	_, err := conc.Map([]int{1}, func(v int) (int, error) { return v, nil }, conc.WithRampDownOnShutdown(-1))
+	assert.Error(t, err)
+}
+
+func TestMapLogger(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	_, err := conc.Map([]string{"6", "2", "a", "76"}, strconv.Atoi, conc.WithLogger(logger))
+	assert.Error(t, err)
+	assert.Contains(t, buf.String(), "map started")
+	assert.Contains(t, buf.String(), `msg="map value failed" index=2`)
+
+	// A nil logger disables the logging, neither the earlier logger nor the default logger is used
+	buf.Reset()
+	defaultBuf := &bytes.Buffer{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(defaultBuf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	_, err = conc.Map([]string{"6", "2", "a", "76"}, strconv.Atoi, conc.WithLogger(logger), conc.WithLogger(nil))
+	assert.Error(t, err)
+	assert.Empty(t, buf.String())
+	assert.Empty(t, defaultBuf.String())
+}
+
+type tornResult struct {
+	first  *int
+	second *int
+}
+
+func TestMapPanicLeavesZeroValue(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ret, err := conc.Map([]int{1, 2}, func(v int) (r tornResult, err error) {
+		if v == 1 {
+			cancel()
+			r.first, r.second = &v, &v
+			return r, nil
+		}
+
+		<-ctx.Done()
+		r.first = &v
+		panic("failed to construct the second half of the result")
+	}, conc.WithContext(ctx), conc.WithCompleteInFlightOnCancel(time.Second))
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, *ret[0].first)
+	assert.Equal(t, tornResult{}, ret[1])
+}
+
+func TestMapPartitioner(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	const partitions = 3
+	lock := sync.Mutex{}
+	running := make([]int, partitions)
+	maxRunning := 0
+	totalRunning := 0
+
+	ints := make([]int, 60)
+	for i := range ints {
+		ints[i] = i
+	}
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		partition := v % partitions
+		lock.Lock()
+		running[partition]++
+		totalRunning++
+		if running[partition] > 1 {
+			t.Errorf("partition %d is processed by more than one worker at once", partition)
+		}
+		if totalRunning > maxRunning {
+			maxRunning = totalRunning
+		}
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		lock.Lock()
+		running[partition]--
+		totalRunning--
+		lock.Unlock()
+		return v, nil
+	}, conc.WithMaxConcurrency(partitions), conc.WithPartitioner(func(index int, n int) int {
+		return index % partitions
+	}))
+	assert.NoError(t, err)
+	assert.Greater(t, maxRunning, 1)
+}
+
+func TestMapConcurrencyWarmup(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	const interval = 50 * time.Millisecond
+	lock := sync.Mutex{}
+	running := 0
+	maxRunningEarly := 0
+	maxRunning := 0
+
+	start := time.Now()
+	ints := make([]int, 200)
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		if time.Since(start) < interval/2 && running > maxRunningEarly {
+			maxRunningEarly = running
+		}
+		lock.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+		return v, nil
+	}, conc.WithConcurrencyWarmup(1, 4, 1, interval))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, maxRunningEarly)
+	assert.Equal(t, 4, maxRunning)
+
+	_, err = conc.Map(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithConcurrencyWarmup(5, 4, 1, interval))
+	assert.Error(t, err)
+}
+
+func TestMapCancelAfterSuccesses(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	calls := int64(0)
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i + 1
+	}
+	ret, err := conc.Map(ints, func(v int) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		return v, nil
+	}, conc.WithMaxConcurrency(4), conc.WithCancelAfterSuccesses(10))
+	assert.NoError(t, err)
+
+	nonZero := 0
+	for _, v := range ret {
+		if v != 0 {
+			nonZero++
+		}
+	}
+	assert.GreaterOrEqual(t, nonZero, 10)
+	time.Sleep(finishWait)
+	assert.Less(t, atomic.LoadInt64(&calls), int64(30))
+}
+
+func TestMapGrouped(t *testing.T) {
+	ret, err := conc.MapGrouped([]string{"1", "2", "3", "4", "5", "6", "7"}, 3, strconv.Atoi)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]int{{1, 2, 3}, {4, 5, 6}, {7}}, ret)
+
+	ret, err = conc.MapGrouped([]string{}, 3, strconv.Atoi)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]int{}, ret)
+
+	_, err = conc.MapGrouped([]string{"1"}, 0, strconv.Atoi)
+	assert.Equal(t, errors.New("groupSize can't be less than 1, was 0"), err)
+}
+
+func TestMapKeepAlive(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	const idleTimeout = 30 * time.Millisecond
+	ret, err := conc.MapKeepAlive([]int{1, 2}, idleTimeout, func(v int, keepAlive func()) (int, error) {
+		for i := 0; i < 10; i++ {
+			time.Sleep(idleTimeout / 3)
+			keepAlive()
+		}
+		return v, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, ret)
+
+	_, err = conc.MapKeepAlive([]int{1, 2}, idleTimeout, func(v int, keepAlive func()) (int, error) {
+		if v == 2 {
+			time.Sleep(idleTimeout * 2)
+		}
+		return v, nil
+	})
+	assert.ErrorIs(t, err, conc.ErrIdleTimeout)
+	time.Sleep(idleTimeout * 2)
+}
+
+func TestMapInterner(t *testing.T) {
+	interned := map[string]*string{}
+	ret, err := conc.Map([]string{"a", "b", "a", "a", "b"}, func(v string) (*string, error) {
+		return &v, nil
+	}, conc.WithInterner(func(s *string) *string {
+		if existing, ok := interned[*s]; ok {
+			return existing
+		}
+		interned[*s] = s
+		return s
+	}))
+	assert.NoError(t, err)
+	assert.Same(t, ret[0], ret[2])
+	assert.Same(t, ret[0], ret[3])
+	assert.Same(t, ret[1], ret[4])
+	assert.NotSame(t, ret[0], ret[1])
+	assert.Equal(t, "a", *ret[0])
+}
+
+func TestMapExecutionTrace(t *testing.T) {
+	buf := &bytes.Buffer{}
+	_, err := conc.Map([]string{"6", "2", "1"}, strconv.Atoi, conc.WithMaxConcurrency(2), conc.WithExecutionTrace(buf))
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 9)
+	for i := 0; i < 3; i++ {
+		for _, event := range []string{"dispatch", "start", "finish"} {
+			found := false
+			for _, line := range lines {
+				if strings.Contains(line, " "+event+" ") && strings.Contains(line, fmt.Sprintf("index=%d", i)) {
+					found = true
+				}
+			}
+			assert.True(t, found, "missing %s of index %d", event, i)
+		}
+	}
+}
+
+func TestMapToJSONArray(t *testing.T) {
+	type item struct {
+		Value  int    `json:"value"`
+		Square string `json:"square"`
+	}
+
+	ints := make([]int, 100)
+	expected := make([]item, len(ints))
+	for i := range ints {
+		ints[i] = i
+		expected[i] = item{Value: i, Square: fmt.Sprint(i * i)}
+	}
+	expectedJSON, err := json.Marshal(expected)
+	assert.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	err = conc.MapToJSONArray(ints, func(v int) (any, error) {
+		time.Sleep(time.Duration(v%7) * time.Millisecond)
+		return item{Value: v, Square: fmt.Sprint(v * v)}, nil
+	}, buf, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	assert.Equal(t, string(expectedJSON), buf.String())
+
+	buf.Reset()
+	err = conc.MapToJSONArray([]int{}, func(v int) (any, error) {
+		return v, nil
+	}, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]", buf.String())
+
+	buf.Reset()
+	err = conc.MapToJSONArray([]int{1, 2}, func(v int) (any, error) {
+		return make(chan int), nil
+	}, buf)
+	assert.Error(t, err)
+}
+
+func TestMapRollback(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	lock := sync.Mutex{}
+	var succeeded, rolledBack []int
+
+	ints := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		if v == 5 {
+			return 0, errors.New("test error")
+		} else if v > 5 {
+			time.Sleep(finishWait / 4) // Finish after Map has returned
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		succeeded = append(succeeded, v)
+		return v, nil
+	}, conc.WithMaxConcurrency(1), conc.WithRollback(func(v int) {
+		lock.Lock()
+		defer lock.Unlock()
+		rolledBack = append(rolledBack, v)
+	}))
+	assert.Equal(t, errors.New("test error"), err)
+
+	time.Sleep(finishWait)
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []int{4, 3, 2, 1, 0}, rolledBack[:5])
+	assert.ElementsMatch(t, succeeded, rolledBack)
+}
+
+func TestMapWorkQueue(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	links := map[string][]string{
+		"/":      {"/a", "/b"},
+		"/a":     {"/a/1", "/b"},
+		"/b":     {"/a"},
+		"/a/1":   {"/a/1/x"},
+		"/a/1/x": {},
+	}
+
+	queue := &conc.WorkQueue[string]{}
+	visited := map[string]bool{"/": true}
+	lock := sync.Mutex{}
+
+	ret, err := conc.Map([]string{"/"}, func(page string) (string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, link := range links[page] {
+			if !visited[link] {
+				visited[link] = true
+				queue.Add(link)
+			}
+		}
+		return strings.ToUpper(page), nil
+	}, conc.WithWorkQueue(queue), conc.WithMaxConcurrency(2))
+	assert.NoError(t, err)
+	assert.Equal(t, "/", ret[0])
+	assert.ElementsMatch(t, []string{"/", "/A", "/B", "/A/1", "/A/1/X"}, ret)
+
+	_, err = conc.Map([]int{1}, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithWorkQueue(queue))
+	assert.Error(t, err)
+}
+
+func TestMapWorkQueueIndexes(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	// Every value below 10 adds the next value to the queue, which makes one value per round
+	queue := &conc.WorkQueue[int]{}
+	fn := func(i int, v int) (int, error) {
+		if v < 10 {
+			queue.Add(v + 1)
+		}
+		return i, nil
+	}
+
+	ret, err := conc.MapIndexed([]int{0}, fn, conc.WithWorkQueue(queue))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, ret, "queued values should be indexed after previous values")
+
+	var callbacks []int
+	_, err = conc.MapCallback([]int{0}, func(v int) (int, error) {
+		if v < 10 {
+			queue.Add(v + 1)
+		}
+		return v, nil
+	}, func(i int, r int) {
+		assert.Equal(t, i, r)
+		callbacks = append(callbacks, i)
+	}, conc.WithWorkQueue(queue))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, callbacks)
+
+	// Only the first value should be skipped, not the first value of every round
+	ret, err = conc.MapIndexed([]int{0, 1}, func(i int, v int) (int, error) {
+		if i == 0 {
+			t.Error("the resumed value should not be processed")
+		}
+		if v == 1 {
+			queue.Add(2, 3)
+		}
+		return v, nil
+	}, conc.WithWorkQueue(queue), conc.WithResumeFrom([]int{0}))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3}, ret)
+
+	_, err = conc.Map([]int{1}, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithWorkQueue(queue), conc.WithProgress(func(completed, total int) {}))
+	assert.EqualError(t, err, "WithProgress can't be used together with WithWorkQueue")
+
+	_, err = conc.Map([]int{1}, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithWorkQueue(queue), conc.WithCheckpoint(time.Second, func(completed []int) {}))
+	assert.EqualError(t, err, "WithCheckpoint can't be used together with WithWorkQueue")
+
+	_, err = conc.Map([]int{1}, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithWorkQueue(queue), conc.WithContinueOnError())
+	assert.EqualError(t, err, "WithContinueOnError can't be used together with WithWorkQueue")
+
+	_, err = conc.Map([]int{1}, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithWorkQueue(queue), conc.WithMaxErrors(2))
+	assert.EqualError(t, err, "WithMaxErrors can't be used together with WithWorkQueue")
+}
+
+func TestMapWorkQueueTimeout(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	// Every round takes 30ms, so the timeout has to apply to all rounds together to be reached
+	queue := &conc.WorkQueue[int]{}
+	var calls int64
+	start := time.Now()
+	_, err := conc.Map([]int{0}, func(v int) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(30 * time.Millisecond)
+		if v < 10 {
+			queue.Add(v + 1)
+		}
+		return v, nil
+	}, conc.WithWorkQueue(queue), conc.WithTimeout(100*time.Millisecond))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 250*time.Millisecond)
+	assert.Less(t, atomic.LoadInt64(&calls), int64(11))
+}
+
+func TestMapCheckpointAndResume(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	var lastCheckpoint []int
+	checkpoint := conc.WithCheckpoint(time.Millisecond, func(completed []int) {
+		lastCheckpoint = completed
+	})
+
+	ints := []int{1, 2, 3, 4, 5, 6}
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		if v == 4 {
+			return 0, errors.New("interrupted")
+		}
+		return v, nil
+	}, conc.WithMaxConcurrency(1), checkpoint)
+	assert.Error(t, err)
+	assert.Subset(t, lastCheckpoint, []int{0, 1, 2})
+	assert.NotContains(t, lastCheckpoint, 3)
+
+	resumeFrom := lastCheckpoint
+	var processed []int
+	ret, err := conc.Map(ints, func(v int) (int, error) {
+		processed = append(processed, v-1)
+		return v, nil
+	}, conc.WithMaxConcurrency(1), checkpoint, conc.WithResumeFrom(resumeFrom))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int{0, 1, 2, 3, 4, 5}, append(processed, resumeFrom...))
+	for i, v := range ret {
+		assert.Equal(t, !contains(resumeFrom, i), v != 0)
+	}
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, lastCheckpoint)
+}
+
+func contains(ss []int, v int) bool {
+	for _, s := range ss {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMapInFlightCounter(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	const concurrent = 4
+	var inFlight int64
+	maxInFlight := int64(0)
+	lock := sync.Mutex{}
+
+	ints := make([]int, 1000)
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		current := atomic.LoadInt64(&inFlight)
+		lock.Lock()
+		if current > maxInFlight {
+			maxInFlight = current
+		}
+		lock.Unlock()
+		return v, nil
+	}, conc.WithMaxConcurrency(concurrent), conc.WithInFlightCounter(&inFlight))
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, maxInFlight, int64(concurrent))
+	assert.GreaterOrEqual(t, maxInFlight, int64(1))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&inFlight))
+}
+
+func TestMapThen(t *testing.T) {
+	ss := make([]string, 100)
+	for i := range ss {
+		ss[i] = strconv.Itoa(i)
+	}
+	double := func(v int) (string, error) {
+		time.Sleep(time.Duration(v%5) * time.Millisecond)
+		return strconv.Itoa(v * 2), nil
+	}
+
+	ints, err := conc.Map(ss, strconv.Atoi)
+	assert.NoError(t, err)
+	chained, err := conc.Map(ints, double)
+	assert.NoError(t, err)
+
+	fused, err := conc.MapThen(ss, strconv.Atoi, double, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	assert.Equal(t, chained, fused)
+
+	_, err = conc.MapThen([]string{"1", "a"}, strconv.Atoi, double)
+	assert.Error(t, err)
+}
+
+func TestMapMaxGoroutines(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	calls := int64(0)
+	ints := make([]int, 100)
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		return v, nil
+	}, conc.WithMaxConcurrency(50), conc.WithMaxGoroutines(runtime.NumGoroutine()+10))
+	assert.ErrorIs(t, err, conc.ErrGoroutineBudgetExceeded)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&calls))
+
+	_, err = conc.Map(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithMaxConcurrency(5), conc.WithMaxGoroutines(runtime.NumGoroutine()+50))
+	assert.NoError(t, err)
+}
+
+func TestMapDiscardOnCancel(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cleanedUp := int64(0)
+	ret, err := conc.Map([]int{1, 2, 3, 4, 5, 6}, func(v int) (int, error) {
+		if v == 3 {
+			cancel()
+		}
+		return v, nil
+	}, conc.WithMaxConcurrency(1), conc.WithContext(ctx), conc.WithCompleteInFlightOnCancel(time.Second),
+		conc.WithDiscardOnCancel(func(v int) {
+			atomic.AddInt64(&cleanedUp, 1)
+		}))
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, ret)
+	assert.GreaterOrEqual(t, atomic.LoadInt64(&cleanedUp), int64(3))
+}
+
+func TestShared(t *testing.T) {
+	type stats struct {
+		sum    int
+		values map[int]bool
+	}
+	shared := conc.NewShared(stats{values: map[int]bool{}})
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		shared.Update(func(s *stats) {
+			s.sum += v
+			s.values[v] = true
+		})
+		return v, nil
+	}, conc.WithMaxConcurrency(100))
+	assert.NoError(t, err)
+
+	final := shared.Get()
+	assert.Equal(t, bigTestSize*(bigTestSize-1)/2, final.sum)
+	assert.Len(t, final.values, bigTestSize)
+}
+
+func TestMapFailOnWorkerLoss(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	ints[bigTestSize/2] = 1
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		if v == 1 {
+			panic("worker crashed")
+		}
+		return v, errors.New("test error")
+	}, conc.WithMaxConcurrency(10), conc.WithCircuitBreaker(bigTestSize), conc.WithFailOnWorkerLoss())
+	assert.ErrorIs(t, err, conc.ErrWorkerLost)
+	assert.Contains(t, err.Error(), "worker crashed")
+}
+
+func TestMapWarnOnZeroResults(t *testing.T) {
+	lock := sync.Mutex{}
+	var warned []int
+	_, err := conc.Map([]string{"6", "0", "1", "0"}, strconv.Atoi, conc.WithWarnOnZeroResults(func(index int) {
+		lock.Lock()
+		defer lock.Unlock()
+		warned = append(warned, index)
+	}))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int{1, 3}, warned)
+}
+
+func TestMapDoneBuffer(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+	for _, buffer := range []int{0, 1, 16} {
+		ret, err := conc.Map(ints, func(v int) (int, error) {
+			return v + 1, nil
+		}, conc.WithMaxConcurrency(10), conc.WithDoneBuffer(buffer))
+		assert.NoError(t, err)
+		assert.Equal(t, bigTestSize, ret[bigTestSize-1])
+	}
+
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithDoneBuffer(-1))
+	assert.Error(t, err)
+}
+
+func TestMapIntoBuilder(t *testing.T) {
+	ints := make([]int, 100)
+	expected := strings.Builder{}
+	for i := range ints {
+		ints[i] = i
+		fmt.Fprintf(&expected, "%d,", i*i)
+	}
+
+	builder := strings.Builder{}
+	err := conc.MapIntoBuilder(ints, func(v int) (string, error) {
+		time.Sleep(time.Duration(v%5) * time.Millisecond)
+		return fmt.Sprintf("%d,", v*v), nil
+	}, func(b *strings.Builder, index int, r string) {
+		b.WriteString(r)
+	}, &builder, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	assert.Equal(t, expected.String(), builder.String())
+}
+
+func TestOrderedPrecondition(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := []int{0, 1, 2, 3, 4, 5}
+	// The first value is skipped as well, to make sure nothing depends on it
+	skip := conc.WithPrecondition(func(index int) (bool, error) {
+		return index != 0 && index != 2, nil
+	})
+	expected := []int{1, 3, 4, 5}
+
+	buf := &bytes.Buffer{}
+	err := conc.MapToJSONArray(ints, func(v int) (any, error) { return v, nil }, buf, skip)
+	assert.NoError(t, err)
+	assert.Equal(t, "[1,3,4,5]", buf.String())
+
+	var flushed []int
+	err = conc.MapToBatchSink(ints, func(v int) (int, error) { return v, nil }, func(batch []int) error {
+		flushed = append(flushed, batch...)
+		return nil
+	}, 2, skip)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, flushed)
+
+	var built []int
+	err = conc.MapIntoBuilder(ints, func(v int) (int, error) { return v, nil }, func(acc *[]int, _ int, r int) {
+		*acc = append(*acc, r)
+	}, &built, skip)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, built)
+
+	var streamed []int
+	for r := range conc.FlatMapStream(ints, func(v int) ([]int, error) { return []int{v}, nil }, skip, conc.WithOrderedResults()) {
+		assert.NoError(t, r.Err)
+		streamed = append(streamed, r.Value)
+	}
+	assert.Equal(t, expected, streamed)
+
+	results, err := conc.MapChan(ints, func(v int) (int, error) { return v, nil }, skip, conc.WithOrderedResults())
+	assert.NoError(t, err)
+	var received []int
+	for r := range results {
+		assert.NoError(t, r.Err)
+		received = append(received, r.Value)
+	}
+	assert.Equal(t, expected, received)
+}
+
+func TestMapResultSampler(t *testing.T) {
+	sampled := int64(0)
+	ints := make([]int, bigTestSize)
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithMaxConcurrency(10), conc.WithResultSampler(0.1, func(index int, r int) {
+		atomic.AddInt64(&sampled, 1)
+	}))
+	assert.NoError(t, err)
+	assert.InDelta(t, bigTestSize/10, atomic.LoadInt64(&sampled), bigTestSize/50)
+}
+
+func TestForEach(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	sum := int64(0)
+	err := conc.ForEach([]int64{6, 2, 1, 76}, func(v int64) error {
+		atomic.AddInt64(&sum, v)
+		return nil
+	}, conc.WithMaxConcurrency(2))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(85), sum)
+
+	ints := make([]int, bigTestSize)
+	ints[bigTestSize-4] = 1
+	err = conc.ForEach(ints, func(v int) error {
+		if v == 1 {
+			return errors.New("test error")
+		}
+		return nil
+	}, conc.WithMaxConcurrency(10))
+	assert.Equal(t, errors.New("test error"), err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = conc.ForEach(ints, func(v int) error {
+		return nil
+	}, conc.WithContext(ctx))
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestForEachSettings(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	// There is no result slice, so the settings that operate on one are rejected
+	err := conc.ForEach([]int{0, 1, 2}, func(v int) error {
+		return nil
+	}, conc.WithWorkQueue(&conc.WorkQueue[int]{}))
+	assert.EqualError(t, err, "WithWorkQueue is not supported by ForEach")
+
+	err = conc.ForEach([]int{0, 1, 2}, func(v int) error {
+		return nil
+	}, conc.WithCheckpoint(time.Hour, func(completed []int) {}))
+	assert.EqualError(t, err, "WithCheckpoint is not supported by ForEach")
+
+	_, err = conc.AnyMatch([]int{0, 1, 2}, func(v int) (bool, error) {
+		return false, nil
+	}, conc.WithResumeFrom([]int{1}))
+	assert.EqualError(t, err, "WithResumeFrom is not supported by AnyMatch")
+
+	// Other settings work in the same way as with Map
+	var seen []int
+	err = conc.ForEach([]int{0, 1, 2, 3}, func(v int) error {
+		seen = append(seen, v)
+		return nil
+	}, conc.WithMaxConcurrency(1), conc.WithPrecondition(func(i int) (bool, error) {
+		return i != 1, nil
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 2, 3}, seen)
+}
+
+func TestResultSettingsRejected(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := []int{1, 2, 3}
+	fn := func(v int) (int, error) { return v, nil }
+	validator := conc.WithResultValidator(func(int, int) error { return nil })
+
+	err := conc.MapInto(make([]int, len(ints)), ints, fn, conc.WithWorkQueue(&conc.WorkQueue[int]{}))
+	assert.EqualError(t, err, "WithWorkQueue is not supported by MapInto")
+
+	err = conc.MapToBatchSink(ints, fn, func([]int) error { return nil }, 2, conc.WithResumeFrom([]int{0}))
+	assert.EqualError(t, err, "WithResumeFrom is not supported by MapToBatchSink")
+
+	var acc []int
+	err = conc.MapIntoBuilder(ints, fn, func(acc *[]int, _ int, r int) {}, &acc, conc.WithInputSnapshot())
+	assert.EqualError(t, err, "WithInputSnapshot is not supported by MapIntoBuilder")
+
+	err = conc.MapToJSONArray(ints, func(v int) (any, error) { return v, nil }, io.Discard, validator)
+	assert.EqualError(t, err, "WithResultValidator is not supported by MapToJSONArray")
+
+	_, err = conc.MapChan(ints, fn, conc.WithCheckpoint(time.Second, func([]int) {}))
+	assert.EqualError(t, err, "WithCheckpoint is not supported by MapChan")
+
+	var results []conc.Result[int]
+	for r := range conc.FlatMapStream(ints, func(v int) ([]int, error) { return []int{v}, nil }, validator) {
+		results = append(results, r)
+	}
+	if assert.Len(t, results, 1) {
+		assert.EqualError(t, results[0].Err, "WithResultValidator is not supported by FlatMapStream")
+	}
+
+	// Functions that match each value with the result at the same index
+	pred := func(v int) (bool, error) { return v > 1, nil }
+	_, err = conc.Filter(ints, pred, conc.WithResultDedupe(func(b bool) bool { return b }))
+	assert.EqualError(t, err, "WithResultDedupe is not supported by Filter")
+
+	_, _, err = conc.Partition(ints, pred, conc.WithWorkQueue(&conc.WorkQueue[bool]{}))
+	assert.EqualError(t, err, "WithWorkQueue is not supported by Partition")
+
+	_, err = conc.GroupBy(ints, pred, conc.WithCompactSuccessful())
+	assert.EqualError(t, err, "WithCompactSuccessful is not supported by GroupBy")
+
+	_, _, _, err = conc.Find(ints, pred, conc.WithResumeFrom([]int{0}))
+	assert.EqualError(t, err, "WithResumeFrom is not supported by Find")
+
+	_, _, _, err = conc.Find(ints, pred, conc.WithFailureThreshold(0.5))
+	assert.EqualError(t, err, "WithFailureThreshold is not supported by Find")
+
+	_, err = conc.MapSubset(ints, []int{0, 2}, fn, conc.WithResultDedupe(func(v int) int { return v }))
+	assert.EqualError(t, err, "WithResultDedupe is not supported by MapSubset")
+
+	filtered, err := conc.Filter(ints, pred, conc.WithInputSnapshot())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, filtered)
+}
+
+func TestContinueSettingsRejected(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := []int{0, 1, 2, 3, 4, 5}
+	fn := func(v int) (int, error) {
+		if v == 1 {
+			return 0, errors.New("test error")
+		}
+		return v, nil
+	}
+
+	buf := &bytes.Buffer{}
+	err := conc.MapToJSONArray(ints, func(v int) (any, error) { return fn(v) }, buf, conc.WithFailureThreshold(0.5))
+	assert.EqualError(t, err, "WithFailureThreshold is not supported by MapToJSONArray")
+
+	err = conc.MapToBatchSink(ints, fn, func([]int) error { return nil }, 2, conc.WithContinueOnError())
+	assert.EqualError(t, err, "WithContinueOnError is not supported by MapToBatchSink")
+
+	var acc []int
+	err = conc.MapIntoBuilder(ints, fn, func(acc *[]int, _ int, r int) {}, &acc, conc.WithCircuitBreaker(3))
+	assert.EqualError(t, err, "WithCircuitBreaker is not supported by MapIntoBuilder")
+
+	var results []conc.Result[int]
+	for r := range conc.FlatMapStream(ints, func(v int) ([]int, error) {
+		r, err := fn(v)
+		return []int{r}, err
+	}, conc.WithContinueOnError(), conc.WithOrderedResults()) {
+		results = append(results, r)
+	}
+	if assert.Len(t, results, 1) {
+		assert.EqualError(t, results[0].Err, "WithContinueOnError is not supported by FlatMapStream")
+	}
+
+	// Without ordered results, nothing waits for the failed value
+	results = nil
+	for r := range conc.FlatMapStream(ints, func(v int) ([]int, error) {
+		r, err := fn(v)
+		return []int{r}, err
+	}, conc.WithContinueOnError()) {
+		results = append(results, r)
+	}
+	assert.Len(t, results, 6)
+
+	in := make(chan int)
+	close(in)
+	_, errs := conc.MapChanOrdered(in, fn, conc.WithContinueOnError())
+	assert.EqualError(t, <-errs, "WithContinueOnError is not supported by MapChanOrdered")
+}
+
+func TestMapPrecondition(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	calls := int64(0)
+	ret, err := conc.Map([]string{"1", "2", "3", "4", "5"}, func(v string) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		return strconv.Atoi(v)
+	}, conc.WithPrecondition(func(index int) (bool, error) {
+		return index%2 == 0, nil
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 0, 3, 0, 5}, ret)
+	assert.Equal(t, int64(3), calls)
+
+	_, err = conc.Map([]string{"1", "2", "3"}, strconv.Atoi, conc.WithPrecondition(func(index int) (bool, error) {
+		if index == 1 {
+			return false, errors.New("precondition failed")
+		}
+		return true, nil
+	}))
+	assert.Equal(t, errors.New("precondition failed"), err)
+
+	// Skipped values at the end of a sequence are left as the zero value as well
+	ret, err = conc.MapSeq(slices.Values([]string{"1", "2", "3", "4"}), strconv.Atoi,
+		conc.WithPrecondition(func(index int) (bool, error) {
+			return index%2 == 0, nil
+		}))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 0, 3, 0}, ret)
+}
+
+func TestMapIndexed(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ret, err := conc.MapIndexed([]string{"a", "b", "c"}, func(i int, v string) (string, error) {
+		return fmt.Sprintf("%d:%s", i, v), nil
+	}, conc.WithMaxConcurrency(2))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0:a", "1:b", "2:c"}, ret)
+
+	ints := make([]int, bigTestSize)
+	_, err = conc.MapIndexed(ints, func(i int, v int) (int, error) {
+		return 1 / (i - bigTestSize/2), nil // Panics in the middle of the slice
+	}, conc.WithMaxConcurrency(10))
+	assert.EqualError(t, err, "panic: runtime error: integer divide by zero")
+}
+
+func TestFlatMapStream(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := []int{3, 0, 2, 1, 0, 4}
+	var expected []int
+	for i, v := range ints {
+		for j := 0; j < v; j++ {
+			expected = append(expected, i*10+j)
+		}
+	}
+
+	results := conc.FlatMapStream(ints, func(v int) ([]int, error) {
+		time.Sleep(time.Duration(5-v) * time.Millisecond)
+		return make([]int, v), nil
+	}, conc.WithOrderedResults(), conc.WithMaxConcurrency(3))
+
+	var got []int
+	counts := map[int]int{}
+	for r := range results {
+		assert.NoError(t, r.Err)
+		got = append(got, r.Index*10+counts[r.Index])
+		counts[r.Index]++
+	}
+	assert.Equal(t, expected, got)
+
+	results = conc.FlatMapStream([]string{"1", "a"}, func(v string) ([]int, error) {
+		i, err := strconv.Atoi(v)
+		return []int{i}, err
+	})
+	var lastErr conc.Result[int]
+	for r := range results {
+		lastErr = r
+	}
+	assert.Error(t, lastErr.Err)
+	assert.Equal(t, 1, lastErr.Index)
+}
+
+func TestFlatMapStreamStragglers(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	var panics int64
+	results := conc.FlatMapStream([]int{0, 1, 2, 3}, func(v int) ([]int, error) {
+		if v == 0 {
+			return nil, errors.New("failed")
+		}
+		// Still running when the error has been returned
+		time.Sleep(20 * time.Millisecond)
+		return []int{v, v}, nil
+	}, conc.WithMaxConcurrency(4), conc.WithPanicHandler(func(any, []byte) error {
+		atomic.AddInt64(&panics, 1)
+		return nil
+	}))
+
+	var got []conc.Result[int]
+	for r := range results {
+		got = append(got, r)
+	}
+	if assert.NotEmpty(t, got) {
+		last := got[len(got)-1]
+		assert.EqualError(t, last.Err, "failed")
+		assert.Equal(t, 0, last.Index)
+		for _, r := range got[:len(got)-1] {
+			assert.NoError(t, r.Err)
+		}
+	}
+
+	// Wait for the stragglers to try to send their results
+	time.Sleep(50 * time.Millisecond)
+	assert.Zero(t, atomic.LoadInt64(&panics))
+}
+
+// opaqueContext hides that it's based on a context from the context package
+type opaqueContext struct{ context.Context }
+
+func (opaqueContext) Value(key any) any { return nil }
+
+func TestMapReleasesContext(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	// A parent context that isn't cancelled during the test, and which the context package can't see through
+	parent, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ctx := opaqueContext{parent}
+	for i := 0; i < 1000; i++ {
+		_, err := conc.Map([]string{"6", "2", "1", "76"}, strconv.Atoi, conc.WithContext(ctx))
+		assert.NoError(t, err)
+	}
+}
+
+func TestScope(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	scope, err := conc.NewScope(4)
+	assert.NoError(t, err)
+
+	before := runtime.NumGoroutine()
+	maxDuring := int64(0)
+	countGoroutines := func(v int) (int, error) {
+		n := int64(runtime.NumGoroutine())
+		for {
+			current := atomic.LoadInt64(&maxDuring)
+			if n <= current || atomic.CompareAndSwapInt64(&maxDuring, current, n) {
+				break
+			}
+		}
+		return v * 2, nil
+	}
+
+	ints := make([]int, 100)
+	for i := range ints {
+		ints[i] = i
+	}
+	doubled, err := conc.Map(ints, countGoroutines, conc.WithScope(scope))
+	assert.NoError(t, err)
+	quadrupled, err := conc.Map(doubled, countGoroutines, conc.WithScope(scope))
+	assert.NoError(t, err)
+	assert.Equal(t, 396, quadrupled[99])
+
+	// Only the go-routine used internally to wait for the values to be done is allowed to be started
+	assert.LessOrEqual(t, maxDuring, int64(before+1))
+
+	scope.Close()
+
+	_, err = conc.NewScope(0)
+	assert.Error(t, err)
+}
+
+func TestMapTimeout(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	const timeout = 20 * time.Millisecond
+
+	before := time.Now()
+	_, err := conc.Map([]int{1, 2, 3}, func(v int) (int, error) {
+		if v == 2 {
+			time.Sleep(timeout * 3)
+		}
+		return v, nil
+	}, conc.WithTimeout(timeout))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, time.Since(before), timeout*3)
+
+	ret, err := conc.Map([]int{1, 2, 3}, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithTimeout(timeout))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, ret)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = conc.Map([]int{1, 2, 3}, func(v int) (int, error) {
+		time.Sleep(timeout * 3)
+		return v, nil
+	}, conc.WithTimeout(timeout), conc.WithContext(ctx))
+	assert.Equal(t, context.Canceled, err)
+
+	time.Sleep(timeout * 3) // Make sure we don't leave any goroutines behind
+}
+
+func TestMapSubset(t *testing.T) {
+	all := []string{"6", "2", "a", "76", "3"}
+	ret, err := conc.MapSubset(all, []int{4, 0, 3}, strconv.Atoi)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{0: 6, 3: 76, 4: 3}, ret)
+
+	_, err = conc.MapSubset(all, []int{1, 5}, strconv.Atoi)
+	assert.Equal(t, errors.New("index 5 out of range of slice with length 5"), err)
+
+	_, err = conc.MapSubset(all, []int{-1}, strconv.Atoi)
+	assert.Error(t, err)
+
+	_, err = conc.MapSubset(all, []int{2}, strconv.Atoi)
+	assert.Error(t, err)
+}
+
+func TestConcurrencyFromCPUQuota(t *testing.T) {
+	tests := []struct {
+		root       string
+		multiplier float64
+		expected   int
+	}{
+		{root: "testdata/cgroup/v2", multiplier: 1, expected: 2},
+		{root: "testdata/cgroup/v2", multiplier: 4, expected: 6},
+		{root: "testdata/cgroup/v2-unlimited", multiplier: 1, expected: 7},
+		{root: "testdata/cgroup/v1", multiplier: 1, expected: 4},
+		{root: "testdata/cgroup/v1", multiplier: 0.1, expected: 1},
+		{root: "testdata/cgroup/v1-unlimited", multiplier: 1, expected: 7},
+		{root: "testdata/cgroup/none", multiplier: 1, expected: 7},
+		{root: "testdata/cgroup/missing", multiplier: 1, expected: 7},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, conc.ConcurrencyFromCPUQuota(test.root, test.multiplier, 7), test.root)
+	}
+
+	ret, err := conc.Map([]string{"6", "2", "1"}, strconv.Atoi, conc.WithMaxConcurrencyFromCPUQuota(1, 2))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{6, 2, 1}, ret)
+}
+
+func TestMapContinueOnError(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	calls := int64(0)
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		if v == 0 {
+			return 0, errors.New("first error")
+		} else if v%100 == 0 {
+			time.Sleep(time.Millisecond)
+			return 0, errors.New("later error")
+		}
+		return v, nil
+	}, conc.WithMaxConcurrency(10), conc.WithContinueOnError())
+	assert.Equal(t, errors.New("first error"), err)
+	assert.Equal(t, int64(bigTestSize), atomic.LoadInt64(&calls))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = conc.Map(ints, func(v int) (int, error) {
+		if v == 10 {
+			cancel()
+		}
+		return 0, errors.New("test error")
+	}, conc.WithMaxConcurrency(10), conc.WithContinueOnError(), conc.WithContext(ctx))
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestMapAssertOrdering(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+	fn := func(v int) (int, error) {
+		return v * 2, nil
+	}
+
+	mapped, err := conc.Map(ints, fn, conc.WithMaxConcurrency(10), conc.WithAssertOrdering())
+	assert.NoError(t, err)
+	for i, v := range mapped {
+		assert.Equal(t, i*2, v)
+	}
+
+}
+
+func TestPoolMap(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	pool, err := conc.NewPool(4)
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	for i := 0; i < 100; i++ {
+		mapped, err := conc.PoolMap(pool, []int{1, 2, 3, 4, 5, 6, 7, 8}, func(v int) (int, error) {
+			return v * i, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1 * i, 2 * i, 3 * i, 4 * i, 5 * i, 6 * i, 7 * i, 8 * i}, mapped)
+	}
+
+	_, err = conc.PoolMap(pool, []int{1, 2, 3}, func(v int) (int, error) {
+		if v == 2 {
+			return 0, errors.New("test error")
+		}
+		return v, nil
+	})
+	assert.Equal(t, errors.New("test error"), err)
+
+	_, err = conc.NewPool(0)
+	assert.Error(t, err)
+}
+
+func TestPoolMapConcurrentCalls(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	pool, err := conc.NewPool(4)
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wg := sync.WaitGroup{}
+		for c := 0; c < 8; c++ {
+			wg.Add(1)
+			// Half of the calls starts their workers gradually
+			var settings []conc.MapSetting
+			if c%2 == 1 {
+				settings = append(settings, conc.WithConcurrencyWarmup(1, 4, 1, time.Millisecond))
+			}
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 20; i++ {
+					mapped, err := conc.PoolMap(pool, []int{1, 2, 3, 4, 5, 6, 7, 8}, func(v int) (int, error) {
+						time.Sleep(time.Millisecond)
+						return v * c, nil
+					}, settings...)
+					assert.NoError(t, err)
+					assert.Equal(t, []int{1 * c, 2 * c, 3 * c, 4 * c, 5 * c, 6 * c, 7 * c, 8 * c}, mapped)
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("concurrent calls to PoolMap deadlocked")
+	}
+}
+
+func benchmarkInts() []int {
+	ints := make([]int, 16)
+	for i := range ints {
+		ints[i] = i
+	}
+	return ints
+}
+
+func BenchmarkMap(b *testing.B) {
+	ints := benchmarkInts()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = conc.Map(ints, func(v int) (int, error) {
+			return v * 2, nil
+		}, conc.WithMaxConcurrency(4))
+	}
+}
+
+func BenchmarkPoolMap(b *testing.B) {
+	pool, err := conc.NewPool(4)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pool.Close()
+
+	ints := benchmarkInts()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = conc.PoolMap(pool, ints, func(v int) (int, error) {
+			return v * 2, nil
+		})
+	}
+}
+
+func TestMapToBatchSink(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, 1003)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	var batches [][]string
+	err := conc.MapToBatchSink(ints, func(v int) (string, error) {
+		if v%7 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		return strconv.Itoa(v), nil
+	}, func(batch []string) error {
+		batches = append(batches, batch)
+		return nil
+	}, 10, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	if assert.Len(t, batches, 101) {
+		assert.Len(t, batches[100], 3)
+	}
+	i := 0
+	for _, batch := range batches {
+		assert.LessOrEqual(t, len(batch), 10)
+		for _, v := range batch {
+			assert.Equal(t, strconv.Itoa(i), v)
+			i++
+		}
+	}
+	assert.Equal(t, len(ints), i)
+
+	flushes := 0
+	err = conc.MapToBatchSink(ints, func(v int) (int, error) {
+		return v, nil
+	}, func(batch []int) error {
+		flushes++
+		if flushes == 3 {
+			return errors.New("flush error")
+		}
+		return nil
+	}, 10, conc.WithMaxConcurrency(10))
+	assert.Equal(t, errors.New("flush error"), err)
+	assert.Equal(t, 3, flushes)
+
+	err = conc.MapToBatchSink(ints, func(v int) (int, error) {
+		return v, nil
+	}, func(batch []int) error {
+		return nil
+	}, 0)
+	assert.Error(t, err)
+}
+
+func TestFilter(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	filtered, err := conc.Filter(ints, func(v int) (bool, error) {
+		return v%3 == 0, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	assert.Len(t, filtered, (bigTestSize+2)/3)
+	for i, v := range filtered {
+		assert.Equal(t, i*3, v)
+	}
+
+	_, err = conc.Filter(ints, func(v int) (bool, error) {
+		if v == 123 {
+			return false, errors.New("test error")
+		}
+		return true, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.Equal(t, errors.New("test error"), err)
+
+	_, err = conc.Filter(ints, func(v int) (bool, error) {
+		if v == 123 {
+			panic("test panic")
+		}
+		return true, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.Error(t, err)
+}
+
+func TestReduce(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+	sum, err := conc.Reduce(ints, 0, func(v int) (int, error) {
+		return v * 2, nil
+	}, func(acc int, r int) (int, error) {
+		return acc + r, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	assert.Equal(t, bigTestSize*(bigTestSize-1), sum)
+
+	concatenated, err := conc.Reduce([]string{"a", "b", "c", "d"}, ">", func(v string) (string, error) {
+		return strings.ToUpper(v), nil
+	}, func(acc string, r string) (string, error) {
+		return acc + r, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ">ABCD", concatenated)
+
+	_, err = conc.Reduce(ints, 0, func(v int) (int, error) {
+		if v == 123 {
+			return 0, errors.New("map error")
+		}
+		return v, nil
+	}, func(acc int, r int) (int, error) {
+		return acc + r, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.Equal(t, errors.New("map error"), err)
+
+	combined := 0
+	_, err = conc.Reduce(ints, 0, func(v int) (int, error) {
+		return v, nil
+	}, func(acc int, r int) (int, error) {
+		combined++
+		if r == 123 {
+			return 0, errors.New("combine error")
+		}
+		return acc + r, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.Equal(t, errors.New("combine error"), err)
+	assert.Equal(t, 124, combined)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = conc.Reduce(ints, 0, func(v int) (int, error) {
+		return v, nil
+	}, func(acc int, r int) (int, error) {
+		return acc + r, nil
+	}, conc.WithContext(ctx))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMapFailureThreshold(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, 1000)
+	for i := range ints {
+		ints[i] = i
+	}
+	failEvery := func(n int) func(v int) (int, error) {
+		return func(v int) (int, error) {
+			if v%n == 0 {
+				return 0, fmt.Errorf("error %d", v)
+			}
+			return v, nil
+		}
+	}
+
+	mapped, err := conc.Map(ints, failEvery(20), conc.WithMaxConcurrency(10), conc.WithFailureThreshold(0.1))
+	assert.NoError(t, err)
+	for i, v := range mapped {
+		if i%20 == 0 {
+			assert.Equal(t, 0, v)
+		} else {
+			assert.Equal(t, i, v)
+		}
+	}
+
+	_, err = conc.Map(ints, failEvery(5), conc.WithMaxConcurrency(10), conc.WithFailureThreshold(0.1))
+	assert.ErrorIs(t, err, conc.ErrFailureThresholdExceeded)
+	assert.Contains(t, err.Error(), "200 of 1000 values failed")
+
+	_, err = conc.Map(ints, failEvery(20), conc.WithFailureThreshold(1.5))
+	assert.Error(t, err)
+}
+
+func TestMapSeq(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	generator := func(n int) func(yield func(int) bool) {
+		return func(yield func(int) bool) {
+			for i := 0; i < n; i++ {
+				if !yield(i) {
+					return
+				}
+			}
+		}
+	}
+
+	running := int64(0)
+	maxRunning := int64(0)
+	mapped, err := conc.MapSeq(generator(bigTestSize), func(v int) (int, error) {
+		r := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+		for {
+			m := atomic.LoadInt64(&maxRunning)
+			if r <= m || atomic.CompareAndSwapInt64(&maxRunning, m, r) {
+				break
+			}
+		}
+		if v%100 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		return v * 2, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, maxRunning, int64(10))
+	assert.Len(t, mapped, bigTestSize)
+	for i, v := range mapped {
+		assert.Equal(t, i*2, v)
+	}
+
+	strs, err := conc.MapSeq(slices.Values([]string{"a", "b", "c"}), func(v string) (string, error) {
+		return strings.ToUpper(v), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A", "B", "C"}, strs)
+
+	_, err = conc.MapSeq(generator(bigTestSize), func(v int) (int, error) {
+		if v == 123 {
+			return 0, errors.New("test error")
+		}
+		return v, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.Equal(t, errors.New("test error"), err)
+
+	_, err = conc.MapSeq(generator(bigTestSize), func(v int) (int, error) {
+		if v == 123 {
+			panic("test panic")
+		}
+		return v, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pulled := 0
+	_, err = conc.MapSeq(func(yield func(int) bool) {
+		for i := 0; ; i++ {
+			pulled++
+			if i == 100 {
+				cancel()
+			}
+			if !yield(i) {
+				return
+			}
+		}
+	}, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithMaxConcurrency(10), conc.WithContext(ctx))
+	assert.Equal(t, context.Canceled, err)
+	assert.LessOrEqual(t, pulled, 102)
+}
+
+func TestMapSeqSettings(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := []int{0, 1, 2, 3, 4, 5, 6, 7}
+
+	calls := int64(0)
+	_, err := conc.MapSeq(slices.Values(ints), func(v int) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		return 0, fmt.Errorf("error %d", v)
+	}, conc.WithContinueOnError(), conc.WithMaxConcurrency(2))
+	assert.Error(t, err)
+	assert.Equal(t, int64(len(ints)), calls, "every value should be processed when continuing on errors")
+
+	attempts := int64(0)
+	mapped, err := conc.MapSeq(slices.Values([]int{1}), func(v int) (int, error) {
+		if atomic.AddInt64(&attempts, 1) < 3 {
+			return 0, errors.New("transient error")
+		}
+		return v, nil
+	}, conc.WithRetry(3))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, mapped)
+	assert.Equal(t, int64(3), attempts)
+
+	_, err = conc.MapSeq(slices.Values(ints), func(v int) (int, error) {
+		return 0, fmt.Errorf("error %d", v)
+	}, conc.WithMaxErrors(3), conc.WithMaxConcurrency(1))
+	assert.EqualError(t, err, "error 0\nerror 1\nerror 2")
+
+	_, err = conc.MapSeq(slices.Values(ints), func(v int) (int, error) {
+		return v, nil
+	}, conc.WithProgress(func(completed, total int) {}))
+	assert.EqualError(t, err, "WithProgress is not supported by MapSeq")
+
+	_, err = conc.MapSeq(slices.Values(ints), func(v int) (int, error) {
+		return v, nil
+	}, conc.WithResumeFrom([]int{1}))
+	assert.EqualError(t, err, "WithResumeFrom is not supported by MapSeq")
+
+	_, err = conc.MapSeq(slices.Values(ints), func(v int) (int, error) {
+		return v, nil
+	}, conc.WithRampDownOnShutdown(time.Millisecond))
+	assert.EqualError(t, err, "WithRampDownOnShutdown is not supported by MapSeq")
+
+	out, errs := conc.MapChanOrdered(make(chan int), func(v int) (int, error) {
+		return v, nil
+	}, conc.WithPartitioner(func(index, n int) int { return 0 }))
+	for range out {
+	}
+	assert.EqualError(t, <-errs, "WithPartitioner is not supported by MapChanOrdered")
+}
+
+func TestMapSeqPrefetch(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	const pages = 5
+	const pageSize = 10
+	// pagination produces the values a page at a time, where every page takes as long to fetch as it takes
+	// to process all of its values
+	pagination := func(yield func(int) bool) {
+		for page := 0; page < pages; page++ {
+			time.Sleep(pageSize * 2 * time.Millisecond)
+			for i := 0; i < pageSize; i++ {
+				if !yield(page*pageSize + i) {
+					return
+				}
+			}
+		}
+	}
+	process := func(v int) (int, error) {
+		time.Sleep(2 * time.Millisecond)
+		return v, nil
+	}
+
+	start := time.Now()
+	mapped, err := conc.MapSeq(pagination, process, conc.WithMaxConcurrency(1))
+	assert.NoError(t, err)
+	assert.Len(t, mapped, pages*pageSize)
+	withoutPrefetch := time.Since(start)
+
+	// With prefetch, the next page is fetched while the values of the previous one are processed
+	start = time.Now()
+	mapped, err = conc.MapSeq(pagination, process, conc.WithMaxConcurrency(1), conc.WithPrefetch(pageSize))
+	assert.NoError(t, err)
+	assert.Len(t, mapped, pages*pageSize)
+	for i, v := range mapped {
+		assert.Equal(t, i, v)
+	}
+	withPrefetch := time.Since(start)
+	assert.True(t, withPrefetch < withoutPrefetch*4/5,
+		"prefetching took %s, compared to %s without prefetching", withPrefetch, withoutPrefetch)
+
+	// The source is never pulled from more than n values ahead of the values being processed
+	var pulled, processed int64
+	var maxAhead int64
+	_, err = conc.MapSeq(func(yield func(int) bool) {
+		for i := 0; i < 100; i++ {
+			ahead := atomic.AddInt64(&pulled, 1) - atomic.LoadInt64(&processed)
+			if ahead > atomic.LoadInt64(&maxAhead) {
+				atomic.StoreInt64(&maxAhead, ahead)
+			}
+			if !yield(i) {
+				return
+			}
+		}
+	}, func(v int) (int, error) {
+		time.Sleep(100 * time.Microsecond)
+		atomic.AddInt64(&processed, 1)
+		return v, nil
+	}, conc.WithMaxConcurrency(2), conc.WithPrefetch(5))
+	assert.NoError(t, err)
+	// The workers can hold one value each, on top of the prefetched ones
+	assert.LessOrEqual(t, atomic.LoadInt64(&maxAhead), int64(5+2+1))
+
+	// Prefetching stops when MapSeq is aborted
+	_, err = conc.MapSeq(pagination, func(v int) (int, error) {
+		return 0, errors.New("test error")
+	}, conc.WithPrefetch(pageSize))
+	assert.EqualError(t, err, "test error")
+
+	_, err = conc.Map([]int{1}, process, conc.WithPrefetch(1))
+	assert.EqualError(t, err, "WithPrefetch is only supported by MapSeq and MapChanOrdered")
+}
+
+func TestMapChanOrdered(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; i < 1000; i++ {
+			in <- i
+		}
+	}()
+
+	out, errs := conc.MapChanOrdered(in, func(v int) (string, error) {
+		// Shuffle the latencies to make the values finish out of order
+		time.Sleep(time.Duration((v*7919)%5) * time.Millisecond)
+		return strconv.Itoa(v), nil
+	}, conc.WithMaxConcurrency(20))
+
+	i := 0
+	for v := range out {
+		assert.Equal(t, strconv.Itoa(i), v)
+		i++
+	}
+	assert.Equal(t, 1000, i)
+	assert.NoError(t, <-errs)
+
+	buffered := make(chan int, 1000)
+	for i := 0; i < 1000; i++ {
+		buffered <- i
+	}
+	close(buffered)
+	intOut, errs := conc.MapChanOrdered(buffered, func(v int) (int, error) {
+		if v == 123 {
+			return 0, errors.New("test error")
+		}
+		return v, nil
+	}, conc.WithMaxConcurrency(10))
+	for v := range intOut {
+		assert.Less(t, v, 123)
+	}
+	assert.Equal(t, errors.New("test error"), <-errs)
+}
+
+func TestMapChanOrderedPrecondition(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; i < 10; i++ {
+			in <- i
+		}
+	}()
+
+	out, errs := conc.MapChanOrdered(in, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithMaxConcurrency(2), conc.WithPrecondition(func(index int) (bool, error) {
+		return index != 1 && index != 2, nil
+	}))
+
+	var got []int
+	for v := range out {
+		got = append(got, v)
+	}
+	assert.Equal(t, []int{0, 3, 4, 5, 6, 7, 8, 9}, got)
+	assert.NoError(t, <-errs)
+}
+
+func TestMapChanOrderedBounded(t *testing.T) {
+	defer checkGoRoutines(t)()
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+
+	for _, test := range []struct {
+		name     string
+		settings []conc.MapSetting
+		bound    int64
+	}{
+		{"default", nil, 2},
+		{"max concurrency", []conc.MapSetting{conc.WithMaxConcurrency(3)}, 3},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var read int64
+			in := make(chan int)
+			go func() {
+				defer close(in)
+				for i := 0; i < 100; i++ {
+					in <- i
+					atomic.AddInt64(&read, 1)
+				}
+			}()
+
+			// All later results have to be buffered until the first one is done
+			release := make(chan struct{})
+			out, errs := conc.MapChanOrdered(in, func(v int) (int, error) {
+				if v == 0 {
+					<-release
+				}
+				return v, nil
+			}, test.settings...)
+
+			time.Sleep(50 * time.Millisecond)
+			assert.LessOrEqual(t, atomic.LoadInt64(&read), test.bound)
+			close(release)
+
+			i := 0
+			for v := range out {
+				assert.Equal(t, i, v)
+				i++
+			}
+			assert.Equal(t, 100, i)
+			assert.NoError(t, <-errs)
+		})
+	}
+}
+
+func TestMapChan(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, 1000)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	results, err := conc.MapChan(ints, func(v int) (int, error) {
+		if v%100 == 0 {
+			return 0, fmt.Errorf("error %d", v)
+		}
+		return v * 2, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	seen := make([]bool, len(ints))
+	for r := range results {
+		assert.False(t, seen[r.Index])
+		seen[r.Index] = true
+		if r.Index%100 == 0 {
+			assert.Equal(t, fmt.Errorf("error %d", r.Index), r.Err)
+		} else {
+			assert.NoError(t, r.Err)
+			assert.Equal(t, r.Index*2, r.Value)
+		}
+	}
+	for _, s := range seen {
+		assert.True(t, s)
+	}
+
+	results, err = conc.MapChan(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithMaxConcurrency(10), conc.WithOrderedResults())
+	assert.NoError(t, err)
+	i := 0
+	for r := range results {
+		assert.Equal(t, i, r.Index)
+		i++
+	}
+	assert.Equal(t, len(ints), i)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := int64(0)
+	results, err = conc.MapChan(ints, func(v int) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(time.Millisecond)
+		return v, nil
+	}, conc.WithMaxConcurrency(10), conc.WithContext(ctx))
+	assert.NoError(t, err)
+	received := 0
+	for range results {
+		received++
+		if received == 50 {
+			cancel()
+		}
+	}
+	assert.Less(t, atomic.LoadInt64(&calls), int64(len(ints)))
+
+	_, err = conc.MapChan(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithMaxConcurrency(-1))
+	assert.Error(t, err)
+
+	// Errors are emitted as results, so the settings that handle them would never see any
+	_, err = conc.MapChan(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithRetry(3))
+	assert.EqualError(t, err, "WithRetry is not supported by MapChan")
+}
+
+func TestMapChanOutputChannel(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := []int{0, 1, 2, 3, 4}
+	double := func(v int) (int, error) { return v * 2, nil }
+
+	// The same channel is reused by multiple calls, with a reader that is shared by them
+	out := make(chan conc.Result[int])
+	received := make(chan []conc.Result[int])
+	go func() {
+		var results []conc.Result[int]
+		for r := range out {
+			results = append(results, r)
+		}
+		received <- results
+	}()
+
+	for call := 0; call < 3; call++ {
+		done, err := conc.MapChan(ints, double, conc.WithOutputChannel(out), conc.WithOrderedResults())
+		assert.NoError(t, err)
+		_, ok := <-done
+		assert.False(t, ok, "no results should be sent on the returned channel")
+	}
+	// The channel is still open, and owned by the caller
+	close(out)
+	results := <-received
+	if assert.Len(t, results, 3*len(ints)) {
+		for i, r := range results {
+			assert.Equal(t, i%len(ints), r.Index)
+			assert.Equal(t, r.Index*2, r.Value)
+		}
+	}
+
+	_, err := conc.MapChan(ints, double, conc.WithOutputChannel(make(chan conc.Result[string])))
+	assert.Error(t, err)
+}
+
+func TestCompact(t *testing.T) {
+	values, errs := conc.Compact([]conc.Result[int]{
+		{Index: 0, Value: 1},
+		{Index: 1, Err: errors.New("error 1")},
+		{Index: 2, Value: 3},
+		{Index: 3, Err: errors.New("error 3")},
+	})
+	assert.Equal(t, []int{1, 3}, values)
+	assert.Equal(t, []error{errors.New("error 1"), errors.New("error 3")}, errs)
+}
+
+func TestMapCompactSuccessful(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, 1000)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	mapped, err := conc.Map(ints, func(v int) (int, error) {
+		if v%10 == 3 {
+			return 0, fmt.Errorf("error %d", v)
+		}
+		return v, nil
+	}, conc.WithMaxConcurrency(10), conc.WithCompactSuccessful())
+	assert.Len(t, mapped, 900)
+	prev := -1
+	for _, v := range mapped {
+		assert.NotEqual(t, 3, v%10)
+		assert.Greater(t, v, prev)
+		prev = v
+	}
+	assert.Error(t, err)
+	lines := strings.Split(err.Error(), "\n")
+	assert.Len(t, lines, 100)
+	for i, line := range lines {
+		assert.Equal(t, fmt.Sprintf("error %d", i*10+3), line)
+	}
+
+	mapped, err = conc.Map(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithMaxConcurrency(10), conc.WithCompactSuccessful())
+	assert.NoError(t, err)
+	assert.Equal(t, ints, mapped)
+
+	// The failed values are handled like any other error before they are compacted away
+	attempts := make([]int64, len(ints))
+	var resultsLock sync.Mutex
+	var results []int
+	mapped, err = conc.MapCallback(ints, func(v int) (int, error) {
+		if v%10 == 3 && atomic.AddInt64(&attempts[v], 1) == 1 {
+			return 0, fmt.Errorf("transient error %d", v)
+		}
+		if v%10 == 5 {
+			return -1, fmt.Errorf("error %d", v)
+		}
+		return v, nil
+	}, func(_ int, r int) {
+		resultsLock.Lock()
+		results = append(results, r)
+		resultsLock.Unlock()
+	}, conc.WithMaxConcurrency(10), conc.WithCompactSuccessful(), conc.WithRetry(2), conc.WithIndexedErrors(),
+		conc.WithResultValidator(func(_ int, r int) error {
+			if r%10 == 7 {
+				return fmt.Errorf("invalid %d", r)
+			}
+			return nil
+		}),
+	)
+	assert.Len(t, mapped, 800)
+	for _, v := range mapped {
+		assert.NotContains(t, []int{5, 7}, v%10)
+	}
+	assert.Len(t, results, 800)
+	assert.NotContains(t, results, -1)
+	var elementErr *conc.ElementError
+	if assert.ErrorAs(t, err, &elementErr) {
+		assert.Equal(t, 5, elementErr.Index)
+	}
+	lines = strings.Split(err.Error(), "\n")
+	assert.Len(t, lines, 200)
+}
+
+func TestMapRetry(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, 1000)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	attempts := make([]int64, len(ints))
+	failTwice := func(v int) (int, error) {
+		if atomic.AddInt64(&attempts[v], 1) <= 2 {
+			return 0, fmt.Errorf("transient error %d", v)
+		}
+		return v * 2, nil
+	}
+	mapped, err := conc.Map(ints, failTwice, conc.WithMaxConcurrency(10), conc.WithRetry(3))
+	assert.NoError(t, err)
+	for i, v := range mapped {
+		assert.Equal(t, i*2, v)
+		assert.Equal(t, int64(3), attempts[i])
+	}
+
+	attempts = make([]int64, len(ints))
+	_, err = conc.Map(ints, failTwice, conc.WithMaxConcurrency(10), conc.WithRetry(2))
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tries := int64(0)
+	_, err = conc.Map([]int{1}, func(v int) (int, error) {
+		atomic.AddInt64(&tries, 1)
+		cancel()
+		return 0, errors.New("test error")
+	}, conc.WithRetry(10), conc.WithContext(ctx))
+	assert.Error(t, err)
+	time.Sleep(finishWait)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&tries))
+}
+
+func TestMapRetryOrdering(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+	// Every value gets a shuffled latency, so that retried values finish long after the values around them
+	latencies := rand.New(rand.NewSource(1)).Perm(len(ints))
+
+	attempts := make([]int64, len(ints))
+	mapped, err := conc.MapIndexed(ints, func(i int, v int) (string, error) {
+		attempt := atomic.AddInt64(&attempts[i], 1)
+		time.Sleep(time.Duration(latencies[i]%100) * time.Microsecond)
+		// Every third value fails once, and every fifth of them a second time, before succeeding
+		if v%3 == 0 && (attempt == 1 || (attempt == 2 && v%5 == 0)) {
+			return "", fmt.Errorf("transient error %d", v)
+		}
+		return strconv.Itoa(v * 2), nil
+	}, conc.WithMaxConcurrency(50), conc.WithRetry(3))
+	assert.NoError(t, err)
+	if assert.Len(t, mapped, len(ints)) {
+		for i, v := range mapped {
+			assert.Equal(t, strconv.Itoa(i*2), v, "result at index %d", i)
+		}
+	}
+	for i, a := range attempts {
+		expected := int64(1)
+		if i%3 == 0 && i%5 == 0 {
+			expected = 3
+		} else if i%3 == 0 {
+			expected = 2
+		}
+		assert.Equal(t, expected, a, "attempts of index %d", i)
+	}
+}
+
+func TestMapConcurrencyPerHost(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	hosts := []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com"}
+	urls := make([]string, 200)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://%s/page/%d", hosts[i%len(hosts)], i)
+	}
+	hostOf := func(url string) string {
+		return strings.Split(url, "/")[2]
+	}
+
+	lock := sync.Mutex{}
+	running := map[string]int{}
+	maxPerHost := 0
+	maxTotal := 0
+	total := 0
+	_, err := conc.Map(urls, func(url string) (string, error) {
+		host := hostOf(url)
+		lock.Lock()
+		running[host]++
+		total++
+		if running[host] > maxPerHost {
+			maxPerHost = running[host]
+		}
+		if total > maxTotal {
+			maxTotal = total
+		}
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		lock.Lock()
+		running[host]--
+		total--
+		lock.Unlock()
+		return host, nil
+	}, conc.WithMaxConcurrency(20), conc.WithConcurrencyPerHost(2, hostOf))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, maxPerHost)
+	assert.Greater(t, maxTotal, 2, "different hosts should run in parallel")
+
+	_, err = conc.Map(urls, func(url string) (string, error) {
+		return url, nil
+	}, conc.WithConcurrencyPerHost(2, func(i int) string { return "" }))
+	assert.Error(t, err)
+}
+
+func TestConcurrencyPerHostEntryPoints(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, 20)
+	running := int64(0)
+	maxRunning := int64(0)
+	track := func() {
+		n := atomic.AddInt64(&running, 1)
+		for {
+			m := atomic.LoadInt64(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt64(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt64(&running, -1)
+	}
+	oneHost := conc.WithConcurrencyPerHost(1, func(int) string { return "example.com" })
+
+	entryPoints := map[string]func(settings ...conc.MapSetting) error{
+		"ForEach": func(settings ...conc.MapSetting) error {
+			return conc.ForEach(ints, func(v int) error {
+				track()
+				return nil
+			}, settings...)
+		},
+		"MapInto": func(settings ...conc.MapSetting) error {
+			return conc.MapInto(make([]int, len(ints)), ints, func(v int) (int, error) {
+				track()
+				return v, nil
+			}, settings...)
+		},
+		"MapSeq": func(settings ...conc.MapSetting) error {
+			_, err := conc.MapSeq(slices.Values(ints), func(v int) (int, error) {
+				track()
+				return v, nil
+			}, settings...)
+			return err
+		},
+		"MapToJSONArray": func(settings ...conc.MapSetting) error {
+			return conc.MapToJSONArray(ints, func(v int) (any, error) {
+				track()
+				return v, nil
+			}, io.Discard, settings...)
+		},
+	}
+	for name, run := range entryPoints {
+		atomic.StoreInt64(&maxRunning, 0)
+		assert.NoError(t, run(oneHost, conc.WithMaxConcurrency(10)), name)
+		assert.Equal(t, int64(1), atomic.LoadInt64(&maxRunning), name)
+
+		err := run(conc.WithConcurrencyPerHost(1, func(string) string { return "" }))
+		assert.Error(t, err, "%s should reject a host function of the wrong type", name)
+	}
+}
+
+func TestConcurrencyPerHostTimeout(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	// The second value waits for the host, which should be interrupted by the timeout
+	start := time.Now()
+	_, err := conc.Map([]int{1, 2}, func(v int) (int, error) {
+		time.Sleep(200 * time.Millisecond)
+		return v, nil
+	}, conc.WithConcurrencyPerHost(1, func(int) string { return "example.com" }), conc.WithTimeout(20*time.Millisecond))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, time.Since(start), 150*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+}
+
+func TestMapBackoff(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	lock := sync.Mutex{}
+	var attemptTimes []time.Time
+	_, err := conc.Map([]int{1}, func(v int) (int, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		attemptTimes = append(attemptTimes, time.Now())
+		if len(attemptTimes) < 4 {
+			return 0, errors.New("transient error")
+		}
+		return v, nil
+	}, conc.WithRetry(4), conc.WithBackoff(10*time.Millisecond, 2))
+	assert.NoError(t, err)
+	if assert.Len(t, attemptTimes, 4) {
+		for i, wait := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond} {
+			assert.GreaterOrEqual(t, attemptTimes[i+1].Sub(attemptTimes[i]), wait)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	_, err = conc.Map([]int{1, 2, 3}, func(v int) (int, error) {
+		return 0, errors.New("transient error")
+	}, conc.WithRetry(3), conc.WithBackoff(time.Hour, 2), conc.WithContext(ctx))
+	assert.Equal(t, context.Canceled, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// goroutineID returns the id of the current go-routine, as written in its stack trace
+func goroutineID() int {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	id, _ := strconv.Atoi(string(buf[:bytes.IndexByte(buf, ' ')]))
+	return id
+}
+
+func TestOrderingMatrix(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	scope, err := conc.NewScope(4)
+	assert.NoError(t, err)
+	defer scope.Close()
+
+	// noResultSlice are the functions without a result slice, which can't support the settings that operate on it
+	// The checks made by WithAssertOrdering are tested by TestResultsDuplicateWrite
+	noResultSlice := []string{"FlatMapStream", "MapChan", "MapSeq", "MapToBatchSink", "MapIntoBuilder"}
+	// The functions that match each value with the result at the same index only support the input snapshot
+	indexedResults := []string{"Filter", "Partition", "Find"}
+	// unsupported are the functions that should reject the setting, instead of silently ignoring it
+	// The settings that can be observed from the function are checked to take effect, after each run
+	options := []struct {
+		name        string
+		setting     conc.MapSetting
+		unsupported []string
+	}{
+		{"max concurrency", conc.WithMaxConcurrency(7), nil},
+		{"partitioner", conc.WithPartitioner(func(index, n int) int { return index % n }), []string{"MapSeq"}},
+		{"warmup", conc.WithConcurrencyWarmup(2, 8, 2, time.Millisecond), []string{"MapSeq"}},
+		{"done buffer", conc.WithDoneBuffer(1), []string{"MapSeq"}},
+		{"input snapshot", conc.WithInputSnapshot(), noResultSlice},
+		{"assert ordering", conc.WithAssertOrdering(), append(indexedResults, noResultSlice...)},
+		{"scope", conc.WithScope(scope), []string{"MapSeq"}},
+		{"per host", conc.WithConcurrencyPerHost(2, func(v int) string { return strconv.Itoa(v % 5) }), nil},
+		{"retry", conc.WithRetry(2), []string{"MapChan"}},
+	}
+	// outcomes are the settings that change which values are processed, or how their errors and results are handled
+	// At most one of them is used at a time, together with every combination of the options
+	queue := &conc.WorkQueue[int]{}
+	// noContinue are the functions that can't keep going after a value has failed
+	noContinue := []string{"FlatMapStream", "MapToBatchSink", "MapIntoBuilder", "Find"}
+	outcomes := []struct {
+		name        string
+		setting     conc.MapSetting
+		unsupported []string
+	}{
+		{"", nil, nil},
+		{"precondition", conc.WithPrecondition(func(index int) (bool, error) { return index%10 != 9, nil }), nil},
+		{"continue on error", conc.WithContinueOnError(), noContinue},
+		{"failure threshold", conc.WithFailureThreshold(0.05), append([]string{"MapChan"}, noContinue...)},
+		{"result dedupe", conc.WithResultDedupe(func(r int) int { return r / 4 }), append(indexedResults, noResultSlice...)},
+		{"work queue", conc.WithWorkQueue(queue), append(indexedResults, noResultSlice...)},
+	}
+
+	ints := make([]int, 100)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	// Observations of the run in progress, reset before each run
+	var (
+		input          []int
+		selected       map[string]bool
+		running        int64
+		maxRunning     int64
+		hostRunning    [5]int64
+		maxHostRunning int64
+		failed         int32
+		workersLock    sync.Mutex
+		workers        map[int][]int
+	)
+	updateMax := func(m *int64, v int64) {
+		for cur := atomic.LoadInt64(m); v > cur && !atomic.CompareAndSwapInt64(m, cur, v); cur = atomic.LoadInt64(m) {
+		}
+	}
+	// Values take a varying amount of time, to make them finish out of order
+	double := func(v int) (int, error) {
+		updateMax(&maxRunning, atomic.AddInt64(&running, 1))
+		defer atomic.AddInt64(&running, -1)
+		updateMax(&maxHostRunning, atomic.AddInt64(&hostRunning[v%5], 1))
+		defer atomic.AddInt64(&hostRunning[v%5], -1)
+
+		id := goroutineID()
+		workersLock.Lock()
+		workers[id] = append(workers[id], v)
+		workersLock.Unlock()
+
+		// The copy made by the input snapshot is not affected
+		if selected["input snapshot"] && v == 0 {
+			input[len(input)-1] = -1
+		}
+		// Only succeeds if the value is retried
+		if selected["retry"] && v == 42 && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+			return 0, errors.New("transient error")
+		}
+		// Always fails, and is handled by the outcome setting
+		if (selected["continue on error"] || selected["failure threshold"]) && v == 13 {
+			return 0, errors.New("error 13")
+		}
+		if selected["work queue"] && v == 50 {
+			queue.Add(100, 101)
+		}
+
+		for i := 0; i < (v*7919)%13; i++ {
+			runtime.Gosched()
+		}
+		return v * 2, nil
+	}
+	var expectedEven, expectedOdd []int
+	for _, v := range ints {
+		if v%2 == 0 {
+			expectedEven = append(expectedEven, v)
+		} else {
+			expectedOdd = append(expectedOdd, v)
+		}
+	}
+
+	// expected returns the results of the values of a function that returns a result for every value, where the
+	// values that are skipped or fail are left as the zero value, or a function that streams them, where they are
+	// left out
+	expected := func(stream bool) []int {
+		var want []int
+		for _, v := range ints {
+			switch {
+			case selected["precondition"] && v%10 == 9 && stream:
+			case selected["precondition"] && v%10 == 9, selected["failure threshold"] && v == 13:
+				want = append(want, 0)
+			default:
+				want = append(want, v*2)
+			}
+		}
+		if selected["work queue"] {
+			want = append(want, 200, 202)
+		}
+		if selected["result dedupe"] {
+			seen := map[int]bool{}
+			want = slices.DeleteFunc(want, func(r int) bool {
+				duplicate := seen[r/4]
+				seen[r/4] = true
+				return duplicate
+			})
+		}
+		return want
+	}
+
+	funcs := []struct {
+		name string
+		run  func(ints []int, settings []conc.MapSetting) ([]int, error)
+		want func() []int
+	}{
+		{"Map", func(ints []int, settings []conc.MapSetting) ([]int, error) {
+			return conc.Map(ints, double, settings...)
+		}, func() []int { return expected(false) }},
+		{"MapIndexed", func(ints []int, settings []conc.MapSetting) ([]int, error) {
+			return conc.MapIndexed(ints, func(_ int, v int) (int, error) { return double(v) }, settings...)
+		}, func() []int { return expected(false) }},
+		{"Filter", func(ints []int, settings []conc.MapSetting) ([]int, error) {
+			return conc.Filter(ints, func(v int) (bool, error) {
+				r, err := double(v)
+				return r%4 == 0, err
+			}, settings...)
+		}, func() []int { return expectedEven }},
+		{"Partition", func(ints []int, settings []conc.MapSetting) ([]int, error) {
+			matched, unmatched, err := conc.Partition(ints, func(v int) (bool, error) {
+				r, err := double(v)
+				return r%4 == 0, err
+			}, settings...)
+			return append(matched, unmatched...), err
+		}, func() []int { return append(append([]int(nil), expectedEven...), expectedOdd...) }},
+		{"Find", func(ints []int, settings []conc.MapSetting) ([]int, error) {
+			// The values still running when the match has been decided are waited for, to not be observed by
+			// the next run
+			settings = append(settings[:len(settings):len(settings)], conc.WithCompleteInFlightOnCancel(time.Minute))
+			v, i, found, err := conc.Find(ints, func(v int) (bool, error) {
+				r, err := double(v)
+				return r == 154, err
+			}, settings...)
+			if !found {
+				return nil, err
+			}
+			return []int{v, i}, err
+		}, func() []int { return []int{77, 77} }},
+		{"FlatMapStream", func(ints []int, settings []conc.MapSetting) ([]int, error) {
+			var ret []int
+			var err error
+			for r := range conc.FlatMapStream(ints, func(v int) ([]int, error) {
+				r, err := double(v)
+				return []int{r}, err
+			}, append(settings, conc.WithOrderedResults())...) {
+				if r.Err != nil {
+					err = r.Err
+				}
+				ret = append(ret, r.Value)
+			}
+			return ret, err
+		}, func() []int { return expected(true) }},
+		{"MapChan", func(ints []int, settings []conc.MapSetting) ([]int, error) {
+			results, err := conc.MapChan(ints, double, append(settings, conc.WithOrderedResults())...)
+			if err != nil {
+				return nil, err
+			}
+			var ret []int
+			for r := range results {
+				if r.Err != nil {
+					err = r.Err
+				}
+				ret = append(ret, r.Value)
+			}
+			return ret, err
+		}, func() []int { return expected(true) }},
+		{"MapSeq", func(ints []int, settings []conc.MapSetting) ([]int, error) {
+			return conc.MapSeq(slices.Values(ints), double, settings...)
+		}, func() []int { return expected(false) }},
+		{"MapToBatchSink", func(ints []int, settings []conc.MapSetting) ([]int, error) {
+			var ret []int
+			err := conc.MapToBatchSink(ints, double, func(batch []int) error {
+				ret = append(ret, batch...)
+				return nil
+			}, 7, settings...)
+			return ret, err
+		}, func() []int { return expected(true) }},
+		{"MapIntoBuilder", func(ints []int, settings []conc.MapSetting) ([]int, error) {
+			var ret []int
+			err := conc.MapIntoBuilder(ints, double, func(acc *[]int, _ int, r int) {
+				*acc = append(*acc, r)
+			}, &ret, settings...)
+			return ret, err
+		}, func() []int { return expected(true) }},
+	}
+
+	for combination := 0; combination < len(outcomes)<<len(options); combination++ {
+		var names []string
+		var settings []conc.MapSetting
+		unsupported := map[string]bool{}
+		selected = map[string]bool{}
+		for i, o := range options {
+			if combination&(1<<i) != 0 {
+				names = append(names, o.name)
+				selected[o.name] = true
+				settings = append(settings, o.setting)
+				for _, name := range o.unsupported {
+					unsupported[name] = true
+				}
+			}
+		}
+		if outcome := outcomes[combination>>len(options)]; outcome.setting != nil {
+			names = append(names, outcome.name)
+			selected[outcome.name] = true
+			settings = append(settings, outcome.setting)
+			for _, name := range outcome.unsupported {
+				unsupported[name] = true
+			}
+		}
+
+		maxConcurrency := int64(len(ints))
+		if selected["max concurrency"] {
+			maxConcurrency = 7
+		}
+		// The target of the warmup replaces the max concurrency, since it comes later in the settings
+		if selected["warmup"] {
+			maxConcurrency = 8
+		}
+		if selected["scope"] {
+			maxConcurrency = min(maxConcurrency, 4)
+		}
+
+		for _, f := range funcs {
+			input = slices.Clone(ints)
+			maxRunning, maxHostRunning, failed = 0, 0, 0
+			workers = map[int][]int{}
+
+			got, err := f.run(input, settings)
+			if unsupported[f.name] {
+				assert.Error(t, err, "%s should reject %v", f.name, names)
+				continue
+			}
+			if selected["continue on error"] {
+				// Every value is processed before the error is returned
+				processed := map[int]bool{}
+				for _, values := range workers {
+					for _, v := range values {
+						processed[v] = true
+					}
+				}
+				assert.EqualError(t, err, "error 13", "%s with %v", f.name, names)
+				assert.Len(t, processed, len(ints), "%s with %v", f.name, names)
+				continue
+			}
+			if !assert.NoError(t, err, "%s with %v", f.name, names) {
+				continue
+			}
+			assert.Equal(t, f.want(), got, "%s with %v", f.name, names)
+
+			assert.True(t, maxRunning <= maxConcurrency, "%s with %v ran %d values at once", f.name, names, maxRunning)
+			if selected["per host"] {
+				assert.True(t, maxHostRunning <= 2, "%s with %v ran %d values per host", f.name, names, maxHostRunning)
+			}
+			if selected["retry"] {
+				assert.Equal(t, int32(1), failed, "%s with %v", f.name, names)
+			}
+			if selected["scope"] {
+				assert.True(t, len(workers) <= 4, "%s with %v used %d workers", f.name, names, len(workers))
+			}
+			// Every worker only gets the values of its own partition. The values added to the work queue are
+			// processed by the workers of a later round, which are partitioned by themselves
+			if selected["partitioner"] && !selected["work queue"] {
+				for _, values := range workers {
+					for _, v := range values {
+						assert.Equal(t, values[0]%len(workers), v%len(workers), "%s with %v", f.name, names)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMapRateLimit(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, 30)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	start := time.Now()
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithMaxConcurrency(30), conc.WithRateLimit(10, 100*time.Millisecond))
+	assert.NoError(t, err)
+	// The first 10 calls are made at once, and the remaining 20 at 10 per 100ms
+	assert.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	start = time.Now()
+	_, err = conc.Map(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithRateLimit(1, time.Hour), conc.WithContext(ctx))
+	assert.Equal(t, context.Canceled, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, err = conc.Map(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithRateLimit(1, 0))
+	assert.Error(t, err)
+}
+
+func TestMapPanicHandler(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	type panicValue struct {
+		index int
+	}
+	var recovered any
+	var stack []byte
+	_, err := conc.Map([]int{1, 2, 3}, func(v int) (int, error) {
+		if v == 2 {
+			panic(panicValue{index: v})
+		}
+		return v, nil
+	}, conc.WithPanicHandler(func(r any, s []byte) error {
+		recovered, stack = r, s
+		return errors.New("handled panic")
+	}))
+	assert.Equal(t, errors.New("handled panic"), err)
+	assert.Equal(t, panicValue{index: 2}, recovered)
+	assert.NotEmpty(t, stack)
+	assert.Contains(t, string(stack), "TestMapPanicHandler")
+
+	_, err = conc.Map([]int{1, 2, 3}, func(v int) (int, error) {
+		if v == 2 {
+			panic("test panic")
+		}
+		return v, nil
+	}, conc.WithPanicHandler(func(r any, s []byte) error {
+		return nil
+	}))
+	assert.EqualError(t, err, "panic: test panic")
+}
+
+func TestMapIndexedErrors(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	testErr := errors.New("test error")
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		if v == 4321 {
+			return 0, testErr
+		}
+		return v, nil
+	}, conc.WithMaxConcurrency(10), conc.WithIndexedErrors())
+	var elementErr *conc.ElementError
+	if assert.True(t, errors.As(err, &elementErr)) {
+		assert.Equal(t, 4321, elementErr.Index)
+	}
+	assert.ErrorIs(t, err, testErr)
+	assert.EqualError(t, err, "value at index 4321: test error")
+
+	_, err = conc.Map(ints, func(v int) (int, error) {
+		if v == 1234 {
+			panic("test panic")
+		}
+		return v, nil
+	}, conc.WithMaxConcurrency(10), conc.WithIndexedErrors())
+	if assert.True(t, errors.As(err, &elementErr)) {
+		assert.Equal(t, 1234, elementErr.Index)
+	}
+	var panicErr *conc.PanicError
+	assert.True(t, errors.As(err, &panicErr))
+
+	_, err = conc.Map(ints, func(v int) (int, error) {
+		if v == 4321 {
+			return 0, testErr
+		}
+		return v, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.Equal(t, testErr, err)
+}
+
+func TestMapProgress(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	lock := sync.Mutex{}
+	var reported []int
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithMaxConcurrency(10), conc.WithProgress(func(completed, total int) {
+		assert.Equal(t, bigTestSize, total)
+		lock.Lock()
+		reported = append(reported, completed)
+		lock.Unlock()
+	}))
+	assert.NoError(t, err)
+	sort.Ints(reported)
+	assert.Len(t, reported, bigTestSize)
+	for i, completed := range reported {
+		assert.Equal(t, i+1, completed)
+	}
+
+	maxCompleted := int64(0)
+	_, err = conc.Map([]int{1, 2, 3, 4}, func(v int) (int, error) {
+		if v == 3 {
+			panic("test panic")
+		}
+		return v, nil
+	}, conc.WithMaxConcurrency(1), conc.WithContinueOnError(), conc.WithProgress(func(completed, total int) {
+		atomic.StoreInt64(&maxCompleted, int64(completed))
+	}))
+	assert.Error(t, err)
+	assert.Equal(t, int64(3), atomic.LoadInt64(&maxCompleted))
+
+	// Values skipped by the precondition are done as well
+	maxCompleted = 0
+	_, err = conc.Map([]int{1, 2, 3, 4}, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithMaxConcurrency(1), conc.WithPrecondition(func(index int) (bool, error) {
+		return index != 2, nil
+	}), conc.WithProgress(func(completed, total int) {
+		atomic.StoreInt64(&maxCompleted, int64(completed))
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), atomic.LoadInt64(&maxCompleted))
+}
+
+func TestMapCtx(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	mapped, err := conc.MapCtx([]int{1, 2, 3}, func(ctx context.Context, v int) (int, error) {
+		assert.NoError(t, ctx.Err())
+		return v * 2, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 4, 6}, mapped)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	aborted := int64(0)
+	start := time.Now()
+	_, err = conc.MapCtx(make([]int, 100), func(ctx context.Context, v int) (int, error) {
+		<-ctx.Done()
+		atomic.AddInt64(&aborted, 1)
+		return 0, ctx.Err()
+	}, conc.WithMaxConcurrency(10), conc.WithContext(ctx))
+	assert.Equal(t, context.Canceled, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, err = conc.MapCtx(make([]int, 100), func(ctx context.Context, v int) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}, conc.WithMaxConcurrency(10), conc.WithTimeout(10*time.Millisecond))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	var doneCtx context.Context
+	_, err = conc.MapCtx([]int{1, 2, 3}, func(ctx context.Context, v int) (int, error) {
+		if v == 2 {
+			return 0, errors.New("test error")
+		}
+		<-ctx.Done()
+		return v, nil
+	}, conc.WithDoneContext(&doneCtx))
+	assert.Equal(t, errors.New("test error"), err)
+	assert.Equal(t, context.Canceled, doneCtx.Err())
+
+	time.Sleep(finishWait)
+	assert.GreaterOrEqual(t, atomic.LoadInt64(&aborted), int64(10), "all in-flight calls should be aborted")
+}
+
+func TestMapCancelNoLeak(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize*10)
+	for round := 0; round < 20; round++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := int64(0)
+		var cancelledAt atomic.Value
+		lateCalls := int64(0)
+		_, err := conc.Map(ints, func(v int) (int, error) {
+			if c, ok := cancelledAt.Load().(time.Time); ok && time.Since(c) > 2*time.Millisecond {
+				atomic.AddInt64(&lateCalls, 1)
+			}
+			if atomic.AddInt64(&calls, 1) == 100 {
+				cancelledAt.Store(time.Now())
+				cancel()
+			}
+			time.Sleep(5 * time.Millisecond)
+			return v, nil
+		}, conc.WithMaxConcurrency(50), conc.WithContext(ctx), conc.WithDoneBuffer(1))
+		assert.Equal(t, context.Canceled, err)
+
+		time.Sleep(finishWait)
+		// Workers stop as soon as they see the cancellation, instead of processing the values left in the channel
+		assert.Equal(t, int64(0), atomic.LoadInt64(&lateCalls))
+		cancel()
+	}
+}
+
+func TestMapPartialResults(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, 100)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var completed []int
+	ret, err := conc.Map(ints, func(v int) (int, error) {
+		if v == 5 {
+			cancel()
+			return 0, ctx.Err()
+		}
+		return v + 1, nil
+	}, conc.WithMaxConcurrency(1), conc.WithContext(ctx), conc.WithPartialResults(),
+		conc.WithCheckpoint(time.Hour, func(c []int) {
+			completed = c
+		}))
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, completed)
+	if assert.Len(t, ret, len(ints)) {
+		for i, v := range ret {
+			if contains(completed, i) {
+				assert.Equal(t, i+1, v)
+			} else {
+				assert.Equal(t, 0, v)
+			}
+		}
+	}
+
+	ret, err = conc.Map(ints, func(v int) (int, error) {
+		if v == 5 {
+			return 0, errors.New("test error")
+		}
+		return v + 1, nil
+	}, conc.WithMaxConcurrency(1))
+	assert.Equal(t, errors.New("test error"), err)
+	assert.Nil(t, ret)
+}
+
+func TestMap2(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	keys, values, err := conc.Map2([]string{"a=1", "b=2", "c=3"}, func(v string) (string, string, error) {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return "", "", fmt.Errorf("invalid pair %q", v)
+		}
+		return key, value, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+	assert.Equal(t, []string{"1", "2", "3"}, values)
+
+	keys, values, err = conc.Map2([]string{"a=1", "b", "c=3"}, func(v string) (string, string, error) {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return "", "", fmt.Errorf("invalid pair %q", v)
+		}
+		return key, value, nil
+	})
+	assert.Equal(t, errors.New(`invalid pair "b"`), err)
+	assert.Nil(t, keys)
+	assert.Nil(t, values)
+}
+
+func TestMapZip(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	as := make([]int, bigTestSize)
+	bs := make([]int, bigTestSize)
+	for i := range as {
+		as[i] = i
+		bs[i] = i * 10
+	}
+	sums, err := conc.MapZip(as, bs, func(a, b int) (int, error) {
+		return a + b, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	for i, sum := range sums {
+		assert.Equal(t, i*11, sum)
+	}
+
+	_, err = conc.MapZip([]int{1, 2, 3}, []string{"a", "b"}, func(a int, b string) (string, error) {
+		return b + strconv.Itoa(a), nil
+	})
+	assert.Equal(t, errors.New("slices to zip must have the same length, was 3 and 2"), err)
+}
+
+func TestMapInto(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	dst := make([]string, 5)
+	err := conc.MapInto(dst, []int{1, 2, 3}, func(v int) (string, error) {
+		return strconv.Itoa(v), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "2", "3", "", ""}, dst)
+
+	err = conc.MapInto(dst[:2], []int{1, 2, 3}, func(v int) (string, error) {
+		return strconv.Itoa(v), nil
+	})
+	assert.Equal(t, errors.New("destination with length 2 is shorter than the input with length 3"), err)
+
+	err = conc.MapInto(dst, []int{1, 2, 3}, func(v int) (string, error) {
+		if v == 2 {
+			return "", errors.New("test error")
+		}
+		return strconv.Itoa(v), nil
+	})
+	assert.Equal(t, errors.New("test error"), err)
+}
+
+func BenchmarkMapInto(b *testing.B) {
+	ints := benchmarkInts()
+	dst := make([]int, len(ints))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = conc.MapInto(dst, ints, func(v int) (int, error) {
+			return v * 2, nil
+		}, conc.WithMaxConcurrency(4))
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+	groups, err := conc.GroupBy(ints, func(v int) (int, error) {
+		return v % 3, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	assert.Len(t, groups, 3)
+	for key, group := range groups {
+		for i, v := range group {
+			assert.Equal(t, i*3+key, v)
+		}
+	}
+
+	_, err = conc.GroupBy(ints, func(v int) (string, error) {
+		if v == 123 {
+			return "", errors.New("test error")
+		}
+		return "", nil
+	}, conc.WithMaxConcurrency(10))
+	assert.Equal(t, errors.New("test error"), err)
+
+	_, err = conc.GroupBy(ints, func(v int) (int, error) {
+		return 1 / (v - 123), nil
+	}, conc.WithMaxConcurrency(10))
+	assert.EqualError(t, err, "panic: runtime error: integer divide by zero")
+}
+
+func TestPartition(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+	even, odd, err := conc.Partition(ints, func(v int) (bool, error) {
+		return v%2 == 0, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	assert.Len(t, even, bigTestSize/2)
+	assert.Len(t, odd, bigTestSize/2)
+	for i := range even {
+		assert.Equal(t, i*2, even[i])
+		assert.Equal(t, i*2+1, odd[i])
+	}
+
+	even, odd, err = conc.Partition(ints, func(v int) (bool, error) {
+		if v == 123 {
+			return false, errors.New("test error")
+		}
+		return v%2 == 0, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.Equal(t, errors.New("test error"), err)
+	assert.Nil(t, even)
+	assert.Nil(t, odd)
+}
+
+func TestAnyMatch(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	for _, target := range []int{3, bigTestSize - 3} {
+		calls := int64(0)
+		match, err := conc.AnyMatch(ints, func(v int) (bool, error) {
+			atomic.AddInt64(&calls, 1)
+			return v == target, nil
+		}, conc.WithMaxConcurrency(10))
+		assert.NoError(t, err)
+		assert.True(t, match)
+		if target == 3 {
+			time.Sleep(finishWait)
+			assert.Less(t, atomic.LoadInt64(&calls), int64(bigTestSize/2), "should stop after the first match")
+		}
+	}
+
+	match, err := conc.AnyMatch(ints, func(v int) (bool, error) {
+		return v < 0, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	assert.False(t, match)
+
+	_, err = conc.AnyMatch(ints, func(v int) (bool, error) {
+		if v == 123 {
+			return false, errors.New("test error")
+		}
+		return false, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.Equal(t, errors.New("test error"), err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = conc.AnyMatch(ints, func(v int) (bool, error) {
+		return false, nil
+	}, conc.WithContext(ctx))
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestAllMatch(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	for _, target := range []int{3, bigTestSize - 3} {
+		calls := int64(0)
+		match, err := conc.AllMatch(ints, func(v int) (bool, error) {
+			atomic.AddInt64(&calls, 1)
+			return v != target, nil
+		}, conc.WithMaxConcurrency(10))
+		assert.NoError(t, err)
+		assert.False(t, match)
+		if target == 3 {
+			time.Sleep(finishWait)
+			assert.Less(t, atomic.LoadInt64(&calls), int64(bigTestSize/2), "should stop after the first mismatch")
+		}
+	}
+
+	match, err := conc.AllMatch(ints, func(v int) (bool, error) {
+		return v >= 0, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	assert.True(t, match)
+
+	_, err = conc.AllMatch(ints, func(v int) (bool, error) {
+		if v == 123 {
+			return false, errors.New("test error")
+		}
+		return true, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.Equal(t, errors.New("test error"), err)
+}
+
+func TestFind(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	// Every multiple of 7 from 70 matches, but the later ones are faster to check
+	v, i, found, err := conc.Find(ints, func(v int) (bool, error) {
+		if v < 100 {
+			time.Sleep(time.Duration(100-v) * 50 * time.Microsecond)
+		}
+		return v >= 70 && v%7 == 0, nil
+	}, conc.WithMaxConcurrency(20))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 70, v)
+	assert.Equal(t, 70, i)
+
+	v, i, found, err = conc.Find(ints, func(v int) (bool, error) {
+		return v == bigTestSize-1, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, bigTestSize-1, v)
+	assert.Equal(t, bigTestSize-1, i)
+
+	_, i, found, err = conc.Find(ints, func(v int) (bool, error) {
+		return v < 0, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, -1, i)
+
+	_, i, found, err = conc.Find(ints, func(v int) (bool, error) {
+		if v == 123 {
+			return false, errors.New("test error")
+		}
+		return v == 5000, nil
+	}, conc.WithMaxConcurrency(10))
+	assert.Equal(t, errors.New("test error"), err)
+	assert.False(t, found)
+	assert.Equal(t, -1, i)
+
+	// An error after the match has been decided does not change the result
+	v, _, found, err = conc.Find(ints, func(v int) (bool, error) {
+		switch v {
+		case 3:
+			return true, nil
+		case 10:
+			time.Sleep(20 * time.Millisecond)
+			return false, errors.New("test error")
+		}
+		return false, nil
+	}, conc.WithMaxConcurrency(20))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 3, v)
+
+	// Values skipped by the precondition don't hold back the match after them
+	v, i, found, err = conc.Find(ints, func(v int) (bool, error) {
+		return v%2 == 1, nil
+	}, conc.WithMaxConcurrency(10), conc.WithPrecondition(func(i int) (bool, error) {
+		return i%2 == 0 || i > 30, nil
+	}))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 31, v)
+	assert.Equal(t, 31, i)
+}
+
+func TestChanWaitGroup(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	wg := conc.NewChanWaitGroup(10)
+	defer wg.Stop()
+	for i := 0; i < 10; i++ {
+		go func() {
+			time.Sleep(time.Millisecond)
+			wg.Done()
+		}()
+	}
+
+	select {
+	case <-wg.Wait():
+	case <-time.After(time.Second):
+		t.Fatal("wait should be done after all calls to Done")
+	}
+
+	// Extra calls are ignored
+	wg.Done()
+	wg.Done()
+}
+
+func TestChanWaitGroupSelect(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	wg := conc.NewChanWaitGroup(2)
+	defer wg.Stop()
+	wg.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	select {
+	case <-wg.Wait():
+		t.Fatal("wait should not be done before the counter reaches zero")
+	case <-ctx.Done():
+	}
+}
+
+func TestChanWaitGroupStop(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	wg := conc.NewChanWaitGroup(5)
+	wg.Done()
+	wg.Stop()
+
+	select {
+	case <-wg.Wait():
+	case <-time.After(time.Second):
+		t.Fatal("wait should be done after stop")
+	}
+
+	// Safe after stop
+	wg.Done()
+	wg.Stop()
+
+	empty := conc.NewChanWaitGroup(0)
+	defer empty.Stop()
+	select {
+	case <-empty.Wait():
+	case <-time.After(time.Second):
+		t.Fatal("wait should be done right away with a size of zero")
+	}
+
+	negative := conc.NewChanWaitGroup(-1)
+	defer negative.Stop()
+	select {
+	case <-negative.Wait():
+	case <-time.After(time.Second):
+		t.Fatal("wait should be done right away with a negative size")
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	sem, err := conc.NewSemaphore(2)
+	assert.NoError(t, err)
+
+	assert.NoError(t, sem.Acquire(context.Background()))
+	assert.True(t, sem.TryAcquire())
+	assert.False(t, sem.TryAcquire())
+
+	acquired := make(chan struct{})
+	go func() {
+		assert.NoError(t, sem.Acquire(context.Background()))
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquire should block while the semaphore is full")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	sem.Release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("acquire should be done after a release")
+	}
+	sem.Release()
+	sem.Release()
+
+	_, err = conc.NewSemaphore(0)
+	assert.EqualError(t, err, "semaphore size can't be less than 1, was 0")
+}
+
+func TestSemaphoreCancelledAcquire(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	sem, err := conc.NewSemaphore(1)
+	assert.NoError(t, err)
+	assert.NoError(t, sem.Acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, sem.Acquire(ctx))
+
+	// The cancelled acquire should not hold the semaphore
+	sem.Release()
+	assert.True(t, sem.TryAcquire())
+	sem.Release()
+}
+
+func TestSemaphoreWeighted(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	sem, err := conc.NewSemaphore(5)
+	assert.NoError(t, err)
+
+	assert.NoError(t, sem.AcquireN(context.Background(), 3))
+	assert.False(t, sem.TryAcquireN(3))
+
+	acquired := make(chan struct{})
+	go func() {
+		assert.NoError(t, sem.AcquireN(context.Background(), 4))
+		close(acquired)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// Waiting acquires are served in order, so a lighter acquire can't skip the line
+	assert.False(t, sem.TryAcquire())
+
+	sem.ReleaseN(3)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("weighted acquire should be done after a release")
+	}
+	assert.True(t, sem.TryAcquire())
+	assert.False(t, sem.TryAcquire())
+	sem.ReleaseN(5)
+
+	assert.Error(t, sem.AcquireN(context.Background(), 6))
+	assert.Panics(t, func() { sem.Release() })
+}
+
+func TestMapCallback(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, bigTestSize)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	// Not locked, since the calls to the callback are serialized
+	var indices []int
+	ret, err := conc.MapCallback(ints, func(v int) (int, error) {
+		return v * 2, nil
+	}, func(i int, r int) {
+		assert.Equal(t, i*2, r)
+		indices = append(indices, i)
+	}, conc.WithMaxConcurrency(20))
+	assert.NoError(t, err)
+	assert.Len(t, ret, bigTestSize)
+
+	sort.Ints(indices)
+	assert.Equal(t, ints, indices)
+}
+
+func TestMapCallbackErrors(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, 100)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	var indices []int
+	_, err := conc.MapCallback(ints, func(v int) (int, error) {
+		if v%10 == 0 {
+			return 0, errors.New("test error")
+		}
+		return v, nil
+	}, func(i int, r int) {
+		indices = append(indices, i)
+	}, conc.WithContinueOnError(), conc.WithMaxConcurrency(10))
+	assert.Equal(t, errors.New("test error"), err)
+
+	var expected []int
+	for _, v := range ints {
+		if v%10 != 0 {
+			expected = append(expected, v)
+		}
+	}
+	sort.Ints(indices)
+	assert.Equal(t, expected, indices)
+}
+
+func TestMaxErrors(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, 500)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	// Every tenth value fails, which makes 50 failures
+	failed := int64(0)
+	_, err := conc.Map(ints, func(v int) (int, error) {
+		time.Sleep(time.Millisecond)
+		if v%10 == 0 {
+			atomic.AddInt64(&failed, 1)
+			return 0, fmt.Errorf("error %d", v)
+		}
+		return v, nil
+	}, conc.WithMaxErrors(10), conc.WithMaxConcurrency(5))
+	assert.Error(t, err)
+	joined, ok := err.(interface{ Unwrap() []error })
+	if assert.True(t, ok) {
+		assert.Len(t, joined.Unwrap(), 10)
+	}
+	time.Sleep(finishWait)
+	assert.GreaterOrEqual(t, atomic.LoadInt64(&failed), int64(10))
+	assert.LessOrEqual(t, atomic.LoadInt64(&failed), int64(15), "should abort after 10 errors")
+
+	// Fewer errors than the max
+	_, err = conc.Map(ints, func(v int) (int, error) {
+		if v%100 == 0 {
+			return 0, fmt.Errorf("error %d", v)
+		}
+		return v, nil
+	}, conc.WithMaxErrors(10), conc.WithMaxConcurrency(5))
+	assert.Error(t, err)
+	for _, v := range []int{0, 100, 200, 300, 400} {
+		assert.Contains(t, err.Error(), fmt.Sprintf("error %d", v))
+	}
+
+	// Fail fast, like the default
+	_, err = conc.Map(ints, func(v int) (int, error) {
+		if v == 123 {
+			return 0, errors.New("test error")
+		}
+		return v, nil
+	}, conc.WithMaxErrors(1))
+	assert.Equal(t, errors.New("test error"), err)
+
+	_, err = conc.Map(ints, func(v int) (int, error) {
+		return v, nil
+	}, conc.WithMaxErrors(-1))
+	assert.EqualError(t, err, "max errors can't be negative, was -1")
+}
+
+func TestMapBatch(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+
+	batches := int64(0)
+	ret, err := conc.MapBatch(ints, 3, func(batch []int) ([]string, error) {
+		atomic.AddInt64(&batches, 1)
+		assert.LessOrEqual(t, len(batch), 3)
+		r := make([]string, len(batch))
+		for i, v := range batch {
+			r[i] = strconv.Itoa(v)
+		}
+		return r, nil
+	}, conc.WithMaxConcurrency(2))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), batches)
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, ret)
+
+	_, err = conc.MapBatch(ints, 3, func(batch []int) ([]string, error) {
+		return []string{"wrong"}, nil
+	})
+	assert.EqualError(t, err, "batch of 3 values returned 1 results")
+
+	_, err = conc.MapBatch(ints, 3, func(batch []int) ([]string, error) {
+		return nil, errors.New("test error")
+	})
+	assert.Equal(t, errors.New("test error"), err)
+
+	_, err = conc.MapBatch(ints, 0, func(batch []int) ([]string, error) {
+		return nil, nil
+	})
+	assert.EqualError(t, err, "batch size can't be less than 1, was 0")
+}
+
+func TestMapWithWorker(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, 100)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	type worker struct {
+		busy int32
+	}
+	inits := int64(0)
+	cleanups := int64(0)
+	ret, err := conc.MapWithWorker(ints, func() (*worker, error) {
+		atomic.AddInt64(&inits, 1)
+		return &worker{}, nil
+	}, func(w *worker, v int) (int, error) {
+		assert.True(t, atomic.CompareAndSwapInt32(&w.busy, 0, 1), "a worker resource should only be used by one call at a time")
+		defer atomic.StoreInt32(&w.busy, 0)
+		time.Sleep(5 * time.Millisecond)
+		return v * 2, nil
+	}, func(w *worker) {
+		atomic.AddInt64(&cleanups, 1)
+	}, conc.WithMaxConcurrency(10))
+	assert.NoError(t, err)
+	for i, r := range ret {
+		assert.Equal(t, i*2, r)
+	}
+	assert.Equal(t, int64(10), inits)
+	assert.Equal(t, int64(10), cleanups)
+}
+
+func TestMapWithWorkerInitError(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	ints := make([]int, 100)
+	for i := range ints {
+		ints[i] = i
+	}
+
+	inits := int64(0)
+	cleanups := int64(0)
+	_, err := conc.MapWithWorker(ints, func() (int, error) {
+		if atomic.AddInt64(&inits, 1) == 5 {
+			return 0, errors.New("init error")
+		}
+		return 0, nil
+	}, func(w int, v int) (int, error) {
+		time.Sleep(5 * time.Millisecond)
+		return v, nil
+	}, func(w int) {
+		atomic.AddInt64(&cleanups, 1)
+	}, conc.WithMaxConcurrency(10))
+	assert.Equal(t, errors.New("init error"), err)
+	assert.Equal(t, atomic.LoadInt64(&inits)-1, atomic.LoadInt64(&cleanups), "every created resource should be cleaned up")
+}
+
+func TestFuture(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	f := conc.Go(func() (int, error) {
+		time.Sleep(time.Millisecond)
+		return 42, nil
+	})
+	v, err := f.Await()
+	assert.NoError(t, err)
+	assert.Equal(t, 42, v)
+
+	// Awaiting again gives the same result
+	v, err = f.AwaitCtx(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 42, v)
+
+	_, err = conc.Go(func() (int, error) {
+		return 0, errors.New("test error")
+	}).Await()
+	assert.Equal(t, errors.New("test error"), err)
+}
+
+func TestFuturePanic(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	_, err := conc.Go(func() (int, error) {
+		panic("test panic")
+	}).Await()
+	assert.EqualError(t, err, "panic: test panic")
+
+	var panicErr *conc.PanicError
+	if assert.True(t, errors.As(err, &panicErr)) {
+		assert.Equal(t, "test panic", panicErr.Value)
+		assert.Equal(t, -1, panicErr.Index)
+		assert.NotEmpty(t, panicErr.Stack)
+	}
+}
+
+func TestFutureCancel(t *testing.T) {
+	defer checkGoRoutines(t)()
+
+	release := make(chan struct{})
+	f := conc.Go(func() (int, error) {
+		<-release
+		return 42, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := f.AwaitCtx(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// The function keeps running, and finishes once it returns
+	close(release)
+	v, err := f.Await()
+	assert.NoError(t, err)
+	assert.Equal(t, 42, v)
+}
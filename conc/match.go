@@ -0,0 +1,141 @@
+package conc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// AnyMatch calls the predicate concurrently with the values of the slice, and reports whether it returned true for
+// any of them. It stops as soon as a match is found, without waiting for the remaining values
+// Errors and panics are handled in the same way as with Map, unless a match has already been found
+// Settings that operate on a result slice are not supported, in the same way as with ForEach
+func AnyMatch[TYPE any](ss []TYPE, pred func(TYPE) (bool, error), settings ...MapSetting) (bool, error) {
+	return matchAny("AnyMatch", ss, pred, true, settings)
+}
+
+// AllMatch calls the predicate concurrently with the values of the slice, and reports whether it returned true for
+// all of them. It stops as soon as a value that does not match is found, without waiting for the remaining values
+// Errors and panics are handled in the same way as with Map, unless a value that does not match has already been found
+// Settings that operate on a result slice are not supported, in the same way as with ForEach
+func AllMatch[TYPE any](ss []TYPE, pred func(TYPE) (bool, error), settings ...MapSetting) (bool, error) {
+	mismatch, err := matchAny("AllMatch", ss, pred, false, settings)
+	if err != nil {
+		return false, err
+	}
+	return !mismatch, nil
+}
+
+// matchAny reports whether the predicate returned want for any of the values, and cancels the run as soon as it does
+func matchAny[TYPE any](fnName string, ss []TYPE, pred func(TYPE) (bool, error), want bool, settings []MapSetting) (bool, error) {
+	// The cancel func is derived from the context of the settings, so that a cancelled context is still an error
+	var cancel context.CancelFunc
+	settings = append(settings[:len(settings):len(settings)], func(mo *mapOptions) {
+		mo.ctx, cancel = context.WithCancel(mo.ctx)
+	})
+
+	var matched atomic.Bool
+	err := forEach(fnName, ss, func(v TYPE) error {
+		m, err := pred(v)
+		if err != nil {
+			return err
+		}
+		if m == want {
+			matched.Store(true)
+			cancel()
+		}
+		return nil
+	}, settings)
+	if cancel != nil {
+		cancel()
+	}
+
+	if matched.Load() {
+		return true, nil
+	}
+	return false, err
+}
+
+// Find calls the predicate concurrently with the values of the slice, and returns the matching value with the
+// lowest index, together with its index. If no value matches, index is -1 and found is false
+// The result is always the same as if the values were checked one by one in order: a match is only returned once
+// the predicate has been called with every value before it, after which the remaining work is cancelled
+// Errors and panics are handled in the same way as with Map, unless they happen after the match has been decided
+// Values skipped by WithPrecondition never match. Settings that keep going after a value has failed, like
+// WithContinueOnError, are not supported, and neither are the settings that operate on the result slice, except
+// WithInputSnapshot
+func Find[TYPE any](ss []TYPE, pred func(TYPE) (bool, error), settings ...MapSetting) (value TYPE, index int, found bool, err error) {
+	ss, settings, err = indexedInput("Find", ss, settings)
+	if err != nil {
+		return value, -1, false, err
+	}
+	// A value that failed is neither a match nor a mismatch, so the values after it could never be decided
+	options, err := newMapOptions(len(ss), settings)
+	if err != nil {
+		return value, -1, false, err
+	}
+	if err := unsupportedSettings("Find", options.continueSettings()); err != nil {
+		return value, -1, false, err
+	}
+
+	var cancel context.CancelFunc
+	lock := sync.Mutex{}
+	best := len(ss)
+	checked := make([]bool, len(ss))
+	frontier := 0 // The lowest index that has not been checked yet
+	check := func(i int, match bool) {
+		lock.Lock()
+		defer lock.Unlock()
+		if match && i < best {
+			best = i
+		}
+		checked[i] = true
+		for frontier < len(checked) && checked[frontier] {
+			frontier++
+		}
+		if best < frontier {
+			cancel()
+		}
+	}
+
+	settings = append(settings[:len(settings):len(settings)], func(mo *mapOptions) {
+		mo.ctx, cancel = context.WithCancel(mo.ctx)
+		// Values skipped by the precondition don't match, but have to be checked for the values after them to match
+		if precondition := mo.precondition; precondition != nil {
+			mo.precondition = func(i int) (bool, error) {
+				ok, err := precondition(i)
+				if err == nil && !ok {
+					check(i, false)
+				}
+				return ok, err
+			}
+		}
+	})
+
+	_, err = MapIndexed(ss, func(i int, v TYPE) (struct{}, error) {
+		lock.Lock()
+		skip := i > best
+		lock.Unlock()
+		if skip {
+			check(i, false)
+			return struct{}{}, nil
+		}
+
+		match, err := pred(v)
+		if err != nil {
+			return struct{}{}, err
+		}
+		check(i, match)
+		return struct{}{}, nil
+	}, settings...)
+	if cancel != nil {
+		cancel()
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if best < frontier {
+		return ss[best], best, true, nil
+	}
+	return value, -1, false, err
+}
@@ -0,0 +1,17 @@
+package conc
+
+import "fmt"
+
+// PanicError is the error returned when the function panics, and can be extracted with errors.As
+type PanicError struct {
+	// Value is the value that was recovered from the panic
+	Value any
+	// Stack is the stack trace of the panic
+	Stack []byte
+	// Index is the index of the value that the function panicked with, or -1 if it was not called with a value
+	Index int
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
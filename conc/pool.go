@@ -0,0 +1,30 @@
+package conc
+
+// Pool is a fixed set of long-lived workers that can be used by many calls to PoolMap
+// It avoids the cost of starting and stopping the workers in each call, which can dominate when Map
+// is called repeatedly with small slices
+type Pool struct {
+	scope *Scope
+}
+
+// NewPool starts a pool with maxConcurrency workers, Close has to be called to stop them
+func NewPool(maxConcurrency int) (*Pool, error) {
+	scope, err := NewScope(maxConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	return &Pool{scope: scope}, nil
+}
+
+// Close stops all workers of the pool, once the calls using them are done
+// The pool must not be used after it has been closed
+func (p *Pool) Close() {
+	p.scope.Close()
+}
+
+// PoolMap works like Map, but runs the function in the workers of the pool
+// Calls that runs at the same time share the workers of the pool, see Scope for details
+func PoolMap[TYPE any, RET any](p *Pool, ss []TYPE, fn func(v TYPE) (RET, error), settings ...MapSetting) ([]RET, error) {
+	settings = append(settings[:len(settings):len(settings)], WithScope(p.scope))
+	return Map(ss, fn, settings...)
+}
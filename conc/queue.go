@@ -0,0 +1,41 @@
+package conc
+
+import "sync"
+
+// WorkQueue makes it possible to add more values to a running Map, for example when the work is discovered
+// while processing (like when crawling). The zero value is an empty queue ready to use
+type WorkQueue[TYPE any] struct {
+	lock  sync.Mutex
+	items []TYPE
+}
+
+// Add adds values to be processed by the Map using this queue, it's safe to call from within the function
+func (q *WorkQueue[TYPE]) Add(items ...TYPE) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.items = append(q.items, items...)
+}
+
+// take removes and returns all values in the queue
+func (q *WorkQueue[TYPE]) take() []TYPE {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	items := q.items
+	q.items = nil
+	return items
+}
+
+// WithWorkQueue makes Map process values added to the queue as well, and only return once the queue is empty
+// and no values are being processed. Values added while processing are processed in rounds; once all values
+// of a round are done, the values added during it are processed as the next round
+// The results of added values are appended to the returned slice, in the order they were added, and the values are
+// indexed after all previous values, so settings that use indexes, like WithResumeFrom, refer to the same indexes
+// WithCheckpoint and WithProgress can't be used together with a work queue, since the number of values isn't known
+// Neither can the settings that count failed or successful values, like WithMaxErrors or WithContinueOnError,
+// since they only apply to a single round. WithTimeout applies to all rounds together
+// The value type of the queue has to match the input type of the Map function, otherwise an error is returned
+func WithWorkQueue[TYPE any](queue *WorkQueue[TYPE]) MapSetting {
+	return func(mo *mapOptions) {
+		mo.workQueue = queue
+	}
+}
@@ -0,0 +1,58 @@
+package conc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WithRateLimit makes the function be called at most n times per the duration, across all workers, no matter
+// the max concurrency. Up to n calls can be made at once, after which calls are spread out evenly
+// Every call counts, including retries. Workers waiting for their turn stop waiting if the context is cancelled
+func WithRateLimit(n int, per time.Duration) MapSetting {
+	return func(mo *mapOptions) {
+		mo.rateLimit = n
+		mo.ratePer = per
+	}
+}
+
+// rateLimiter is a token bucket, which holds up to burst tokens and gets a new token every interval
+type rateLimiter struct {
+	lock     sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+func newRateLimiter(n int, per time.Duration) *rateLimiter {
+	return &rateLimiter{
+		interval: per / time.Duration(n),
+		burst:    float64(n),
+		tokens:   float64(n),
+		last:     time.Now(),
+	}
+}
+
+// wait takes a token, and waits until it is available, or the context is done
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.lock.Lock()
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// The token is taken even if it's not available yet, which reserves the next one that will be
+	l.tokens--
+	delay := time.Duration(-l.tokens * float64(l.interval))
+	l.lock.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	if !sleepCtx(ctx, delay) {
+		return ctx.Err()
+	}
+	return nil
+}
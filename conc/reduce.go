@@ -0,0 +1,30 @@
+package conc
+
+// Reduce calls mapFn concurrently with each value of the slice, and then folds the results into initial by
+// calling combine single-threaded, in the same order as the input
+// Only the map phase runs concurrently. combine is called sequentially, since a reduction can only be split up
+// if the combine function is associative, so expensive work should be done in mapFn
+// The first error from either phase is returned. Errors and panics in mapFn are handled in the same way as with Map
+func Reduce[TYPE any, RET any](
+	ss []TYPE,
+	initial RET,
+	mapFn func(TYPE) (RET, error),
+	combine func(acc RET, r RET) (RET, error),
+	settings ...MapSetting,
+) (RET, error) {
+	mapped, err := Map(ss, mapFn, settings...)
+	if err != nil {
+		var zero RET
+		return zero, err
+	}
+
+	acc := initial
+	for _, r := range mapped {
+		acc, err = combine(acc, r)
+		if err != nil {
+			var zero RET
+			return zero, err
+		}
+	}
+	return acc, nil
+}
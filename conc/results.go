@@ -0,0 +1,182 @@
+package conc
+
+import (
+	"fmt"
+	"sync"
+)
+
+// results holds the result slice of a run. Since workers might still be running after a run has
+// returned, the results can be sealed, after which no more values will be set
+type results[RET any] struct {
+	lock   sync.Mutex
+	sealed bool
+	values []RET
+	set    []bool
+
+	// writes counts the writes to each index, if the writes should be verified
+	writes []uint32
+
+	// discard is called with values that are set after the results has been sealed
+	discard func(RET)
+}
+
+func newResults[RET any](size int) *results[RET] {
+	return &results[RET]{
+		values: make([]RET, size),
+		set:    make([]bool, size),
+	}
+}
+
+// countWrites makes the results verify that each value is only written once
+func (r *results[RET]) countWrites() {
+	r.writes = make([]uint32, len(r.values))
+}
+
+// setValue sets the value at index i, unless the results are sealed
+// It should only be called with a fully constructed value, after the function has returned
+// An error is returned if writes are counted, and the value has already been written
+func (r *results[RET]) setValue(i int, value RET) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.writes != nil {
+		r.writes[i]++
+		if r.writes[i] != 1 {
+			return fmt.Errorf("%w: result for index %d written %d times", ErrOrderingViolation, i, r.writes[i])
+		}
+	}
+	if r.sealed {
+		if r.discard != nil {
+			r.discard(value)
+		}
+		return nil
+	}
+	r.values[i] = value
+	r.set[i] = true
+	return nil
+}
+
+// completed returns the indexes of all values that has been set, in order
+func (r *results[RET]) completed() []int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var completed []int
+	for i, set := range r.set {
+		if set {
+			completed = append(completed, i)
+		}
+	}
+	return completed
+}
+
+// seal stops any further values from being set, and returns the values
+func (r *results[RET]) seal() []RET {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sealed = true
+	return r.values
+}
+
+// sealAndDiscard seals the results, and calls discard with all values that has been set, in reverse index order
+// Values that are set after this are also discarded, as they are set
+func (r *results[RET]) sealAndDiscard(discard func(RET)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sealed = true
+	r.discard = discard
+	for i := len(r.values) - 1; i >= 0; i-- {
+		if r.set[i] {
+			discard(r.values[i])
+		}
+	}
+}
+
+// orderedCommitter calls commit with each result in index order, as soon as all results with lower indexes
+// has been committed. Calls to commit are serialized, and stopped once the committer is closed
+type orderedCommitter[RET any] struct {
+	lock    sync.Mutex
+	closed  bool
+	next    int
+	pending map[int]RET
+	// skipped are the indexes that will never get a result, which are stepped over
+	skipped map[int]bool
+	commit  func(index int, r RET) error
+}
+
+func newOrderedCommitter[RET any](start int, commit func(index int, r RET) error) *orderedCommitter[RET] {
+	return &orderedCommitter[RET]{
+		next:    start,
+		pending: map[int]RET{},
+		skipped: map[int]bool{},
+		commit:  commit,
+	}
+}
+
+// add adds the result for the index, and commits all results that are now in order
+func (oc *orderedCommitter[RET]) add(index int, r RET) error {
+	oc.lock.Lock()
+	defer oc.lock.Unlock()
+
+	if oc.closed {
+		return nil
+	}
+	oc.pending[index] = r
+	return oc.commitInOrder()
+}
+
+// skip marks the index as never getting a result, and commits all results that are now in order
+func (oc *orderedCommitter[RET]) skip(index int) error {
+	oc.lock.Lock()
+	defer oc.lock.Unlock()
+
+	if oc.closed {
+		return nil
+	}
+	oc.skipped[index] = true
+	return oc.commitInOrder()
+}
+
+// skipping wraps the precondition, to skip the indexes of the values that it skips
+func (oc *orderedCommitter[RET]) skipping(precondition func(index int) (bool, error)) func(index int) (bool, error) {
+	if precondition == nil {
+		return nil
+	}
+	return func(index int) (bool, error) {
+		ok, err := precondition(index)
+		if err == nil && !ok {
+			if err := oc.skip(index); err != nil {
+				return false, err
+			}
+		}
+		return ok, err
+	}
+}
+
+// commitInOrder commits the results from next and onwards, until one is missing, the lock has to be held
+func (oc *orderedCommitter[RET]) commitInOrder() error {
+	for !oc.closed {
+		if oc.skipped[oc.next] {
+			delete(oc.skipped, oc.next)
+			oc.next++
+			continue
+		}
+		r, ok := oc.pending[oc.next]
+		if !ok {
+			return nil
+		}
+		delete(oc.pending, oc.next)
+		if err := oc.commit(oc.next, r); err != nil {
+			return err
+		}
+		oc.next++
+	}
+	return nil
+}
+
+// close stops any further commits from being made
+func (oc *orderedCommitter[RET]) close() {
+	oc.lock.Lock()
+	defer oc.lock.Unlock()
+	oc.closed = true
+	oc.pending = nil
+	oc.skipped = nil
+}
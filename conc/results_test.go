@@ -0,0 +1,20 @@
+package conc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultsDuplicateWrite(t *testing.T) {
+	r := newResults[int](10)
+	assert.NoError(t, r.setValue(3, 1))
+	assert.NoError(t, r.setValue(3, 2), "duplicate writes should only be detected when counting writes")
+
+	r = newResults[int](10)
+	r.countWrites()
+	assert.NoError(t, r.setValue(3, 1))
+	err := r.setValue(3, 2)
+	assert.ErrorIs(t, err, ErrOrderingViolation)
+	assert.EqualError(t, err, "result ordering violation: result for index 3 written 2 times")
+}
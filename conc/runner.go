@@ -0,0 +1,284 @@
+package conc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// valueRunner calls the function with a single value, with the settings that applies to each value, like retries,
+// rate limiting and how errors are handled. It's shared by run and runSeq, which only differs in how the values are
+// handed out to the workers
+type valueRunner[TYPE any] struct {
+	options mapOptions
+	// size is the number of values, or -1 if it's not known up front
+	size int
+	// setErr aborts the run with the error
+	setErr func(err error)
+
+	hostFn  func(TYPE) string
+	hosts   *keyLimiter
+	limiter *rateLimiter
+	breaker *circuitBreaker
+
+	// enough is closed when enough values has succeeded, if WithCancelAfterSuccesses is used, and onEnough is then
+	// called if it's set
+	enough     chan struct{}
+	enoughOnce sync.Once
+	onEnough   func()
+	successes  int64
+
+	// succeeded and failed are only counted for the logger
+	succeeded, failed int64
+
+	// firstErr is the first error, and failures the number of errors, when continuing on errors
+	firstErr     error
+	firstErrOnce sync.Once
+	failures     int64
+
+	// errs are the errors so far, when aborting after a max number of errors
+	errs     []error
+	errsLock sync.Mutex
+
+	// compacted are the errors of the failed values by index, with WithCompactSuccessful
+	compacted     compactedErrors
+	compactedLock sync.Mutex
+
+	completed int64
+	traceLock sync.Mutex
+}
+
+func newValueRunner[TYPE any](options mapOptions, size int, setErr func(err error)) (*valueRunner[TYPE], error) {
+	hostFn, err := typedSetting[func(TYPE) string](options.hostFn, "host function")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &valueRunner[TYPE]{
+		options: options,
+		size:    size,
+		setErr:  setErr,
+		hostFn:  hostFn,
+		enough:  make(chan struct{}),
+	}
+	if hostFn != nil {
+		r.hosts = newKeyLimiter(options.perHost)
+	}
+	if options.rateLimit > 0 {
+		r.limiter = newRateLimiter(options.rateLimit, options.ratePer)
+	}
+	if options.circuitBreaker > 0 {
+		r.breaker = &circuitBreaker{threshold: options.circuitBreaker}
+	}
+	return r, nil
+}
+
+// logStarted logs that the run has started, and returns the function that logs that it has finished
+func (r *valueRunner[TYPE]) logStarted() func(err error) {
+	if r.options.logger == nil {
+		return func(error) {}
+	}
+
+	startTime := time.Now()
+	args := []any{"max_concurrency", r.options.maxConcurrency}
+	if r.size >= 0 {
+		args = append([]any{"size", r.size}, args...)
+	}
+	r.options.logger.Debug("map started", args...)
+	return func(err error) {
+		r.options.logger.Debug("map finished",
+			"duration", time.Since(startTime),
+			"succeeded", atomic.LoadInt64(&r.succeeded),
+			"failed", atomic.LoadInt64(&r.failed),
+			"error", err,
+		)
+	}
+}
+
+// trace writes an event to the execution trace, if one is used
+func (r *valueRunner[TYPE]) trace(event string, index int, worker int, err error) {
+	if r.options.executionTrace == nil {
+		return
+	}
+	r.traceLock.Lock()
+	defer r.traceLock.Unlock()
+	line := fmt.Sprintf("%s %-8s index=%d", time.Now().Format(time.RFC3339Nano), event, index)
+	if worker >= 0 {
+		line += fmt.Sprintf(" worker=%d", worker)
+	}
+	if err != nil {
+		line += fmt.Sprintf(" error=%q", err.Error())
+	}
+	fmt.Fprintln(r.options.executionTrace, line)
+}
+
+func (r *valueRunner[TYPE]) progress() {
+	if r.options.progress != nil {
+		r.options.progress(int(atomic.AddInt64(&r.completed, 1)), r.size)
+	}
+}
+
+// process calls fn with the value, and handles the outcome of it
+func (r *valueRunner[TYPE]) process(ctx context.Context, i int, v TYPE, worker int, fn func(i int, v TYPE) error) {
+	options := r.options
+
+	if options.inFlightCounter != nil {
+		atomic.AddInt64(options.inFlightCounter, 1)
+		defer atomic.AddInt64(options.inFlightCounter, -1)
+	}
+
+	r.trace("start", i, worker, nil)
+	call := func() error {
+		if r.hosts != nil {
+			release, err := r.hosts.acquire(ctx, r.hostFn(v))
+			if err != nil {
+				return err
+			}
+			defer release()
+		}
+		if r.limiter != nil {
+			if err := r.limiter.wait(ctx); err != nil {
+				return err
+			}
+		}
+		return fn(i, v)
+	}
+	err := call()
+	backoff := options.backoffBase
+	for attempt := 1; err != nil && attempt < options.retryAttempts && ctx.Err() == nil; attempt++ {
+		if backoff > 0 {
+			if !sleepCtx(ctx, backoff) {
+				break
+			}
+			backoff = time.Duration(float64(backoff) * options.backoffFactor)
+		}
+		err = call()
+	}
+	if err != nil && options.indexedErrors {
+		err = &ElementError{Index: i, Err: err}
+	}
+	r.trace("finish", i, worker, err)
+	if options.logger != nil {
+		if err != nil {
+			atomic.AddInt64(&r.failed, 1)
+			options.logger.Info("map value failed", "index", i, "error", err)
+		} else {
+			atomic.AddInt64(&r.succeeded, 1)
+		}
+	}
+
+	if err != nil && options.compactResults {
+		r.compactedLock.Lock()
+		if r.compacted == nil {
+			r.compacted = compactedErrors{}
+		}
+		r.compacted[i] = err
+		r.compactedLock.Unlock()
+	} else if err != nil && r.breaker != nil {
+		if err := r.breaker.failure(err); err != nil {
+			r.setErr(err)
+		}
+	} else if err != nil && options.maxErrors > 0 {
+		r.errsLock.Lock()
+		r.errs = append(r.errs, err)
+		if len(r.errs) >= options.maxErrors {
+			r.setErr(r.joinedErrs())
+		}
+		r.errsLock.Unlock()
+	} else if err != nil && (options.continueOnError || options.failureThreshold != nil) {
+		atomic.AddInt64(&r.failures, 1)
+		r.firstErrOnce.Do(func() { r.firstErr = err })
+	} else if err != nil {
+		r.setErr(err)
+	} else if r.breaker != nil {
+		r.breaker.success()
+	}
+
+	if err == nil && options.cancelAfterSuccesses > 0 &&
+		atomic.AddInt64(&r.successes, 1) >= int64(options.cancelAfterSuccesses) {
+		r.enoughOnce.Do(func() {
+			close(r.enough)
+			if r.onEnough != nil {
+				r.onEnough()
+			}
+		})
+	}
+
+	r.progress()
+}
+
+// panicked handles a panic recovered by a worker, while processing the value at index
+func (r *valueRunner[TYPE]) panicked(recovered any, index int, worker int) {
+	options := r.options
+
+	panicErr := &PanicError{Value: recovered, Stack: debug.Stack(), Index: index}
+	var handled error
+	if options.panicHandler != nil {
+		handled = options.panicHandler(recovered, panicErr.Stack)
+	}
+	if handled == nil && options.failOnWorkerLoss {
+		handled = fmt.Errorf("%w: worker %d exited after %w", ErrWorkerLost, worker, panicErr)
+	} else if handled == nil {
+		handled = panicErr
+	}
+	if options.indexedErrors {
+		handled = &ElementError{Index: index, Err: handled}
+	}
+	r.progress()
+	r.setErr(handled)
+}
+
+// result returns the error of a run where all of the processed values are done, without being aborted
+func (r *valueRunner[TYPE]) result(processed int) error {
+	if r.options.compactResults {
+		r.compactedLock.Lock()
+		defer r.compactedLock.Unlock()
+		if len(r.compacted) > 0 {
+			return r.compacted
+		}
+		return nil
+	}
+
+	if r.breaker != nil {
+		return r.breaker.err()
+	}
+
+	if r.options.failureThreshold != nil {
+		failures := atomic.LoadInt64(&r.failures)
+		if float64(failures) > *r.options.failureThreshold*float64(processed) {
+			return fmt.Errorf("%w: %d of %d values failed, first error: %w", ErrFailureThresholdExceeded, failures, processed, r.firstErr)
+		}
+		return nil
+	}
+
+	if r.options.maxErrors > 0 {
+		r.errsLock.Lock()
+		defer r.errsLock.Unlock()
+		if len(r.errs) > 0 {
+			return r.joinedErrs()
+		}
+		return nil
+	}
+
+	return r.firstErr
+}
+
+// joinedErrs returns the errors so far, errsLock has to be held
+func (r *valueRunner[TYPE]) joinedErrs() error {
+	if len(r.errs) == 1 {
+		return r.errs[0]
+	}
+	return errors.Join(r.errs...)
+}
+
+// compactedErrors are the errors of the values that failed with WithCompactSuccessful, by index. It's returned by a
+// run where all values were processed, so that the failed values can be removed from the results
+type compactedErrors map[int]error
+
+func (e compactedErrors) Error() string {
+	return fmt.Sprintf("%d values failed", len(e))
+}
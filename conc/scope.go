@@ -0,0 +1,87 @@
+package conc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Scope holds a set of go-routines that can be reused as workers by multiple calls, like Map or ForEach,
+// by using the WithScope setting. This avoids starting new go-routines for each call in multi-phase jobs
+// The concurrency of each call is limited by the size of the scope, and calls that runs at the same time
+// share the go-routines. A call waits until all of its workers can be started, before it starts any of them
+// A call made from within a function run by the same scope might therefore block forever
+type Scope struct {
+	size  int
+	tasks chan func()
+	// slots are the go-routines reserved by the calls using the scope
+	slots *Semaphore
+
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewScope starts a scope with size go-routines, Close has to be called to stop them
+func NewScope(size int) (*Scope, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("scope size can't be less than 1, was %d", size)
+	}
+
+	slots, err := NewSemaphore(size)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scope{
+		size:  size,
+		tasks: make(chan func()),
+		slots: slots,
+	}
+	s.wg.Add(size)
+	for i := 0; i < size; i++ {
+		go func() {
+			defer s.wg.Done()
+			for task := range s.tasks {
+				task()
+			}
+		}()
+	}
+	return s, nil
+}
+
+// reserve waits until n go-routines of the scope are free, and reserves them for the workers of one call
+// All workers of a call are reserved at once, since calls that runs at the same time could otherwise each get
+// some of the go-routines, and wait forever for the rest
+func (s *Scope) reserve(ctx context.Context, n int) error {
+	return s.slots.AcquireN(ctx, n)
+}
+
+// release releases reserved go-routines that were never spawned
+func (s *Scope) release(n int) {
+	s.slots.ReleaseN(n)
+}
+
+// spawn runs the function in one of the reserved go-routines of the scope, and releases it once the function returns
+func (s *Scope) spawn(fn func()) {
+	s.tasks <- func() {
+		defer s.slots.Release()
+		fn()
+	}
+}
+
+// Close stops all go-routines of the scope, once the calls using them are done
+// The scope must not be used after it has been closed
+func (s *Scope) Close() {
+	s.closeOnce.Do(func() {
+		close(s.tasks)
+	})
+	s.wg.Wait()
+}
+
+// WithScope makes the workers run in the go-routines of the scope, instead of new go-routines
+// The max concurrency is limited to the size of the scope
+func WithScope(scope *Scope) MapSetting {
+	return func(mo *mapOptions) {
+		mo.scope = scope
+	}
+}
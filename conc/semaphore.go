@@ -0,0 +1,124 @@
+package conc
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Semaphore limits how many can hold it at the same time, for example to bound the concurrency across go-routines
+// Every acquire has a weight, and the combined weight held at the same time never exceeds the size
+// Waiting acquires are served in the order they were made, so a heavy acquire is not starved by lighter ones
+type Semaphore struct {
+	lock    sync.Mutex
+	size    int
+	used    int
+	waiters list.List
+}
+
+type semaphoreWaiter struct {
+	n     int
+	ready chan struct{}
+}
+
+// NewSemaphore creates a semaphore that can be held with a combined weight of size at the same time
+func NewSemaphore(size int) (*Semaphore, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("semaphore size can't be less than 1, was %d", size)
+	}
+	return &Semaphore{size: size}, nil
+}
+
+// Acquire waits until the semaphore can be held, or the context is done, in which case ctx.Err() is returned
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	return s.AcquireN(ctx, 1)
+}
+
+// AcquireN works like Acquire, but with a weight of n
+func (s *Semaphore) AcquireN(ctx context.Context, n int) error {
+	if n < 1 || n > s.size {
+		return fmt.Errorf("can't acquire a weight of %d from a semaphore of size %d", n, s.size)
+	}
+
+	s.lock.Lock()
+	if s.waiters.Len() == 0 && s.used+n <= s.size {
+		s.used += n
+		s.lock.Unlock()
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		s.lock.Unlock()
+		return err
+	}
+	w := &semaphoreWaiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.lock.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		select {
+		case <-w.ready:
+			// The semaphore was acquired at the same time as the context was done
+			s.used -= n
+		default:
+			s.waiters.Remove(elem)
+		}
+		// Waiters behind this one might be able to acquire now
+		s.notify()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire holds the semaphore and returns true if it's available right away, otherwise it returns false
+func (s *Semaphore) TryAcquire() bool {
+	return s.TryAcquireN(1)
+}
+
+// TryAcquireN works like TryAcquire, but with a weight of n
+func (s *Semaphore) TryAcquireN(n int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if n < 1 || s.waiters.Len() > 0 || s.used+n > s.size {
+		return false
+	}
+	s.used += n
+	return true
+}
+
+// Release releases the semaphore after it has been acquired
+func (s *Semaphore) Release() {
+	s.ReleaseN(1)
+}
+
+// ReleaseN releases a weight of n, and panics if more than is held is released
+func (s *Semaphore) ReleaseN(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if n > s.used {
+		panic(fmt.Sprintf("conc: released a weight of %d from a semaphore holding %d", n, s.used))
+	}
+	s.used -= n
+	s.notify()
+}
+
+// notify wakes the waiters in order, for as long as they fit, the lock has to be held
+func (s *Semaphore) notify() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*semaphoreWaiter)
+		if s.used+w.n > s.size {
+			return
+		}
+		s.used += w.n
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
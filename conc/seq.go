@@ -0,0 +1,282 @@
+package conc
+
+import (
+	"context"
+	"iter"
+	"math"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+)
+
+// MapSeq calls the function with each value of the sequence, and returns the results in the same order as the
+// values were produced by the sequence. The values are pulled from the sequence as workers become available,
+// so the sequence is never materialized as a whole. Without WithMaxConcurrency, every value gets its own worker
+// Pulling stops as soon as an error occurs or the context is done, and MapSeq returns once the running calls are done
+// Settings that depend on the number of values or operate on the result slice, like WithProgress, WithCheckpoint or
+// WithResultValidator, and settings that control how the workers are started, like WithPartitioner or WithScope,
+// are not supported and makes MapSeq return an error
+func MapSeq[TYPE any, RET any](seq iter.Seq[TYPE], fn func(TYPE) (RET, error), settings ...MapSetting) ([]RET, error) {
+	options, err := newMapOptions(math.MaxInt, settings)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSeqSettings("MapSeq", options); err != nil {
+		return nil, err
+	}
+
+	// Values skipped by the precondition are left as the zero value, also after the last value that is processed
+	// The precondition is called before dispatching each value, so skippedTo is only used by this go-routine
+	skippedTo := 0
+	if precondition := options.precondition; precondition != nil {
+		options.precondition = func(index int) (bool, error) {
+			ok, err := precondition(index)
+			if err == nil && !ok {
+				skippedTo = index + 1
+			}
+			return ok, err
+		}
+	}
+
+	lock := sync.Mutex{}
+	var ret []RET
+	err = runSeq(seq, func(i int, v TYPE) error {
+		r, err := fn(v)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if i >= len(ret) {
+			ret = append(ret, make([]RET, i+1-len(ret))...)
+		}
+		ret[i] = r
+		return nil
+	}, options)
+	if err != nil {
+		return nil, err
+	}
+	if len(ret) < skippedTo {
+		ret = append(ret, make([]RET, skippedTo-len(ret))...)
+	}
+	return ret, nil
+}
+
+// WithPrefetch makes MapSeq and MapChanOrdered pull up to n values from the source ahead of the workers, in a
+// separate go-routine, into a buffer. This keeps the workers busy with a source that produces values in bursts or
+// with a high latency, like a paginated API, since the next values are produced while the previous are processed
+// The source is still never pulled from more than n values ahead. Other functions already have all values up front,
+// and return an error if it's used
+func WithPrefetch(n int) MapSetting {
+	return func(mo *mapOptions) {
+		mo.prefetch = n
+	}
+}
+
+// prefetch pulls up to n values ahead from seq in a separate go-routine, once the returned sequence is iterated
+// stop has to be called once the returned sequence is done being iterated, and waits for the go-routine to exit
+// A panic in seq is re-raised when iterating the returned sequence
+func prefetch[TYPE any](seq iter.Seq[TYPE], n int) (prefetched iter.Seq[TYPE], stop func()) {
+	// The go-routine holds one value while waiting to send it, so the buffer is one less than n
+	buffer := make(chan TYPE, n-1)
+	stopping := make(chan struct{})
+	done := make(chan struct{})
+	started := false
+	var panicked any
+
+	prefetched = func(yield func(TYPE) bool) {
+		started = true
+		go func() {
+			defer close(done)
+			defer close(buffer)
+			defer func() {
+				panicked = recover()
+			}()
+			for v := range seq {
+				select {
+				case buffer <- v:
+				case <-stopping:
+					return
+				}
+			}
+		}()
+
+		for v := range buffer {
+			if !yield(v) {
+				return
+			}
+		}
+		if panicked != nil {
+			panic(panicked)
+		}
+	}
+	stop = func() {
+		close(stopping)
+		if started {
+			<-done
+		}
+	}
+	return prefetched, stop
+}
+
+// checkSeqSettings returns an error if any settings that runSeq does not support are used
+func checkSeqSettings(fnName string, options mapOptions) error {
+	return unsupportedSettings(fnName, options.resultSettings(), []namedSetting{
+		{"WithPartitioner", options.partitioner != nil},
+		{"WithConcurrencyWarmup", options.warmupStart > 0},
+		{"WithMaxGoroutines", options.maxGoroutines > 0},
+		{"WithDoneBuffer", options.doneBuffer != nil},
+		{"WithScope", options.scope != nil},
+		{"WithCompleteInFlightOnCancel", options.completeInFlightOnCancel > 0},
+		{"WithRampDownOnShutdown", options.rampDownInterval > 0},
+		{"WithProgress", options.progress != nil},
+	})
+}
+
+// runSeq calls fn with each value of the sequence and its index, using at most options.maxConcurrency workers
+// Workers are only started when no other worker is ready to take the next value. The values are handled in the same
+// way as by run, but only the settings that are accepted by checkSeqSettings are supported
+func runSeq[TYPE any](seq iter.Seq[TYPE], fn func(i int, v TYPE) error, options mapOptions) (err error) {
+	ctx, cancel := context.WithCancel(options.ctx)
+	defer cancel()
+	if options.timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, options.timeout)
+		defer cancelTimeout()
+	}
+
+	if options.prefetch > 0 {
+		var stop func()
+		seq, stop = prefetch(seq, options.prefetch)
+		defer stop()
+	}
+
+	var firstErr error
+	errOnce := sync.Once{}
+	setErr := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	runner, err := newValueRunner[TYPE](options, -1, setErr)
+	if err != nil {
+		return err
+	}
+	logFinished := runner.logStarted()
+	defer func() { logFinished(err) }()
+
+	if len(options.doneCtxs) > 0 {
+		doneCtx, doneCancel := context.WithCancel(ctx)
+		defer doneCancel()
+		for _, c := range options.doneCtxs {
+			*c = doneCtx
+		}
+	}
+
+	type job struct {
+		index int
+		value TYPE
+	}
+	jobs := make(chan job)
+	wg := sync.WaitGroup{}
+	startWorker := func(worker int) {
+		labels := make([]string, 0, 2+2*len(options.pprofLabels))
+		for k, v := range options.pprofLabels {
+			labels = append(labels, k, v)
+		}
+		labels = append(labels, "conc_worker", strconv.Itoa(worker))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if options.lockOSThread {
+				runtime.LockOSThread()
+				defer runtime.UnlockOSThread()
+			}
+
+			current := -1
+			defer func() {
+				if r := recover(); r != nil {
+					runner.panicked(r, current, worker)
+					// Keep the dispatch from blocking on a worker that no longer exists
+					for range jobs {
+					}
+				}
+			}()
+			pprof.Do(ctx, pprof.Labels(labels...), func(context.Context) {
+				for j := range jobs {
+					if ctx.Err() != nil {
+						continue
+					}
+					current = j.index
+					runner.process(ctx, j.index, j.value, worker, fn)
+				}
+			})
+		}()
+	}
+
+	workers := 0
+	dispatched := 0
+	i := 0
+dispatch:
+	for v := range seq {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-runner.enough:
+			break dispatch
+		default:
+		}
+		index := i
+		i++
+		if options.precondition != nil {
+			ok, err := options.precondition(index)
+			if err != nil {
+				setErr(err)
+				break
+			} else if !ok {
+				continue
+			}
+		}
+
+		j := job{index: index, value: v}
+		if workers < options.maxConcurrency {
+			select {
+			case jobs <- j:
+				dispatched++
+				runner.trace("dispatch", index, -1, nil)
+				continue
+			default:
+				startWorker(workers)
+				workers++
+			}
+		}
+		select {
+		case jobs <- j:
+			dispatched++
+			runner.trace("dispatch", index, -1, nil)
+		case <-runner.enough:
+			break dispatch
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	select {
+	case <-runner.enough:
+		return nil
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return runner.result(dispatched)
+}
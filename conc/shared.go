@@ -0,0 +1,31 @@
+package conc
+
+import "sync"
+
+// Shared holds a value that can be safely read and updated from multiple go-routines, for example to
+// accumulate state from the functions of a Map call. The zero value holds the zero value of T
+type Shared[T any] struct {
+	lock  sync.Mutex
+	value T
+}
+
+// NewShared creates a Shared holding value
+func NewShared[T any](value T) *Shared[T] {
+	return &Shared[T]{value: value}
+}
+
+// Update calls fn with a pointer to the value, no other calls to Update or Get are made until fn has returned
+// The pointer must not be used after fn has returned
+func (s *Shared[T]) Update(fn func(*T)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fn(&s.value)
+}
+
+// Get returns the value
+// Note that if T contains references (like a slice or map), they are shared with the held value
+func (s *Shared[T]) Get() T {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.value
+}
@@ -0,0 +1,144 @@
+package conc
+
+import (
+	"errors"
+	"sync"
+)
+
+// Result is the outcome of processing one value, used by the functions that stream results
+type Result[RET any] struct {
+	// Index is the index of the input value the result belongs to, or -1 if the error is not tied to a value
+	Index int
+	Value RET
+	Err   error
+}
+
+// Compact splits results into the values of the successful results and the errors of the failed ones,
+// both in the same order as the results
+func Compact[RET any](results []Result[RET]) ([]RET, []error) {
+	var values []RET
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+			continue
+		}
+		values = append(values, r.Value)
+	}
+	return values, errs
+}
+
+// WithOrderedResults makes the functions that stream results emit them in input order, instead of as soon
+// as they are done. Results that are done out of order are buffered until all previous results has been emitted
+func WithOrderedResults() MapSetting {
+	return func(mo *mapOptions) {
+		mo.orderedResults = true
+	}
+}
+
+// FlatMapStream calls the function with each value of the slice, and emits every value of the returned slices
+// on the returned channel as soon as they are produced. Use WithOrderedResults to emit them in input order
+// If an error occurs, it's emitted as the last Result before the channel is closed
+// The channel has to be drained, or the context cancelled, for the workers to be able to finish
+// Using settings that operate on a result slice, like WithResultValidator, makes the stream emit an error, and so does
+// using settings that keep going after a value has failed, like WithContinueOnError, together with WithOrderedResults
+func FlatMapStream[TYPE any, RET any](
+	ss []TYPE,
+	fn func(TYPE) ([]RET, error),
+	settings ...MapSetting,
+) <-chan Result[RET] {
+	out := make(chan Result[RET])
+
+	options, err := newMapOptions(len(ss), settings)
+	if err == nil && options.orderedResults {
+		err = unsupportedSettings("FlatMapStream", options.resultSettings(), options.continueSettings())
+	} else if err == nil {
+		err = unsupportedSettings("FlatMapStream", options.resultSettings())
+	}
+	if err != nil {
+		go func() {
+			out <- Result[RET]{Index: -1, Err: err}
+			close(out)
+		}()
+		return out
+	}
+
+	// Results emitted by workers that are still running after run has returned are dropped, instead of being
+	// sent after the error or on a closed channel
+	lock := sync.Mutex{}
+	closed := false
+	sending := sync.WaitGroup{}
+	send := func(r Result[RET]) {
+		lock.Lock()
+		if closed {
+			lock.Unlock()
+			return
+		}
+		sending.Add(1)
+		lock.Unlock()
+		defer sending.Done()
+
+		select {
+		case out <- r:
+		case <-options.ctx.Done():
+		}
+	}
+	emit := func(index int, rr []RET) error {
+		for _, r := range rr {
+			send(Result[RET]{Index: index, Value: r})
+		}
+		return nil
+	}
+	committer := newOrderedCommitter(0, emit)
+	if options.orderedResults {
+		options.precondition = committer.skipping(options.precondition)
+	}
+
+	go func() {
+		err := run(ss, 0, func(i int, v TYPE) error {
+			rr, err := fn(v)
+			if err != nil {
+				return &indexedError{index: i, err: err}
+			}
+			if options.orderedResults {
+				return committer.add(i, rr)
+			}
+			return emit(i, rr)
+		}, options)
+		committer.close()
+
+		lock.Lock()
+		closed = true
+		lock.Unlock()
+		sending.Wait()
+
+		if err != nil {
+			index := -1
+			var ie *indexedError
+			if errors.As(err, &ie) {
+				index, err = ie.index, ie.err
+			}
+			select {
+			case out <- Result[RET]{Index: index, Err: err}:
+			case <-options.ctx.Done():
+			}
+		}
+		close(out)
+	}()
+
+	return out
+}
+
+// indexedError keeps track of which value an error belongs to, while behaving like the error itself
+type indexedError struct {
+	index int
+	err   error
+}
+
+func (e *indexedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *indexedError) Unwrap() error {
+	return e.err
+}
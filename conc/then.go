@@ -0,0 +1,20 @@
+package conc
+
+// MapThen works like calling Map with first, and then Map with then on the results, but both functions
+// are called after each other by the same worker. This avoids the intermediate slice, and starts the second
+// stage of a value as soon as its first stage is done. The concurrency settings are shared by both stages
+func MapThen[TYPE any, MID any, RET any](
+	ss []TYPE,
+	first func(TYPE) (MID, error),
+	then func(MID) (RET, error),
+	settings ...MapSetting,
+) ([]RET, error) {
+	return Map(ss, func(v TYPE) (RET, error) {
+		mid, err := first(v)
+		if err != nil {
+			var zero RET
+			return zero, err
+		}
+		return then(mid)
+	}, settings...)
+}
@@ -7,23 +7,31 @@ import "sync"
 // `done` is called to decrease the counter
 // `waitCh` is closed when the counter hits zero
 // `stop` will stop the listening and clean up the go-routine, preferable run with `defer stop()`
-func chanWaitGroup(size int) (done func(), waitCh chan struct{}, stop func()) {
-	doneCh := make(chan struct{}, size)
+// `buffer` is the buffer size of the internal channel, a smaller buffer uses less memory but makes `done` block more
+func chanWaitGroup(size int, buffer int) (done func(), waitCh chan struct{}, stop func()) {
+	doneCh := make(chan struct{}, buffer)
 	waitCh = make(chan struct{}, 1)
 	left := size
 
 	stopped := false
 	stopLock := sync.RWMutex{}
+	// stopCh is closed before stop takes the lock, to release calls to done that are blocked on a full buffer
+	stopCh := make(chan struct{})
+	stopOnce := sync.Once{}
 
 	done = func() {
 		stopLock.RLock()
 		defer stopLock.RUnlock()
 		if !stopped {
-			doneCh <- struct{}{}
+			select {
+			case doneCh <- struct{}{}:
+			case <-stopCh:
+			}
 		}
 	}
 
 	stop = func() {
+		stopOnce.Do(func() { close(stopCh) })
 		stopLock.Lock()
 		defer stopLock.Unlock()
 		if !stopped {
@@ -33,6 +41,12 @@ func chanWaitGroup(size int) (done func(), waitCh chan struct{}, stop func()) {
 	}
 
 	go func() {
+		// The counter is already at zero, nothing will be waited for
+		if left <= 0 {
+			close(waitCh)
+			stop()
+			return
+		}
 		for range doneCh {
 			left--
 			if left == 0 {
@@ -46,3 +60,41 @@ func chanWaitGroup(size int) (done func(), waitCh chan struct{}, stop func()) {
 
 	return done, waitCh, stop
 }
+
+// ChanWaitGroup works as a sync.WaitGroup with a fixed counter, but waiting is done by receiving from a channel,
+// which makes it possible to `select` on it together with other conditions, like a context being done
+type ChanWaitGroup struct {
+	done func()
+	wait chan struct{}
+	stop func()
+}
+
+// NewChanWaitGroup creates a ChanWaitGroup with the counter set to size. If size is zero or less,
+// the channel returned by Wait is closed right away
+// Stop should always be called when the ChanWaitGroup is no longer used, preferably with `defer wg.Stop()`,
+// to clean up the go-routine that keeps track of the counter
+func NewChanWaitGroup(size int) *ChanWaitGroup {
+	done, wait, stop := chanWaitGroup(size, max(size, 0))
+	return &ChanWaitGroup{
+		done: done,
+		wait: wait,
+		stop: stop,
+	}
+}
+
+// Done decreases the counter by one. Calls after the counter has reached zero, or after Stop has been called,
+// are ignored, so calling Done more than size times is safe and never makes the counter negative
+func (wg *ChanWaitGroup) Done() {
+	wg.done()
+}
+
+// Wait returns a channel that is closed when the counter reaches zero, or when Stop is called
+func (wg *ChanWaitGroup) Wait() <-chan struct{} {
+	return wg.wait
+}
+
+// Stop stops keeping track of the counter and closes the channel returned by Wait, even if the counter
+// has not reached zero. It's safe to call Stop multiple times, and to call Done after it
+func (wg *ChanWaitGroup) Stop() {
+	wg.stop()
+}
@@ -0,0 +1,106 @@
+package conc
+
+import (
+	"errors"
+	"sync"
+)
+
+// MapWithWorker works like Map, but the function is also called with a worker-local resource, like a connection
+// or a buffer, that is too expensive to create for every value but not safe to share between go-routines
+// Resources are created with initFn the first time they are needed, and at most one per worker is created, so with
+// enough values every worker gets its own. A resource is only used by one call at a time, and reused by later calls
+// Once all calls are done, every resource is passed to cleanupFn. An error from initFn aborts the call in the same
+// way as an error from fn, and MapWithWorker then waits for the in-flight calls before the resources are cleaned up
+func MapWithWorker[TYPE any, RET any, WORKER any](
+	ss []TYPE,
+	initFn func() (WORKER, error),
+	fn func(WORKER, TYPE) (RET, error),
+	cleanupFn func(WORKER),
+	settings ...MapSetting,
+) ([]RET, error) {
+	options, err := newMapOptions(len(ss), settings)
+	if err != nil {
+		return nil, err
+	}
+
+	free := make(chan WORKER, options.maxConcurrency)
+	// failed gets a signal when a resource could not be created, to let calls waiting for a free resource try instead
+	failed := make(chan struct{}, options.maxConcurrency)
+	lock := sync.Mutex{}
+	inits := sync.WaitGroup{}
+	reserved := 0 // The number of resources created or being created
+	created := 0
+	closed := false
+
+	create := func() (w WORKER, err error) {
+		ok := false
+		defer func() {
+			lock.Lock()
+			if ok {
+				created++
+			} else {
+				reserved--
+				select {
+				case failed <- struct{}{}:
+				default:
+				}
+			}
+			lock.Unlock()
+			inits.Done()
+		}()
+		w, err = initFn()
+		ok = err == nil
+		return w, err
+	}
+
+	// get returns a free resource, or creates a new one if not all workers have one yet
+	get := func() (WORKER, error) {
+		for {
+			select {
+			case w := <-free:
+				return w, nil
+			default:
+			}
+
+			lock.Lock()
+			if closed {
+				lock.Unlock()
+				var w WORKER
+				return w, errors.New("map is already done")
+			}
+			if reserved < options.maxConcurrency {
+				reserved++
+				inits.Add(1)
+				lock.Unlock()
+				return create()
+			}
+			lock.Unlock()
+
+			select {
+			case w := <-free:
+				return w, nil
+			case <-failed:
+			}
+		}
+	}
+
+	ret, err := Map(ss, func(v TYPE) (RET, error) {
+		w, err := get()
+		if err != nil {
+			var zero RET
+			return zero, err
+		}
+		defer func() { free <- w }()
+		return fn(w, v)
+	}, settings...)
+
+	lock.Lock()
+	closed = true
+	lock.Unlock()
+	inits.Wait()
+	for i := 0; i < created; i++ {
+		cleanupFn(<-free)
+	}
+
+	return ret, err
+}
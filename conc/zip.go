@@ -0,0 +1,20 @@
+package conc
+
+import "fmt"
+
+// MapZip calls the function with each pair of values at the same index of as and bs, and returns the results in
+// the same order. Both slices need to have the same length, otherwise an error is returned
+func MapZip[TYPE1 any, TYPE2 any, RET any](
+	as []TYPE1,
+	bs []TYPE2,
+	fn func(TYPE1, TYPE2) (RET, error),
+	settings ...MapSetting,
+) ([]RET, error) {
+	if len(as) != len(bs) {
+		return nil, fmt.Errorf("slices to zip must have the same length, was %d and %d", len(as), len(bs))
+	}
+
+	return MapIndexed(as, func(i int, a TYPE1) (RET, error) {
+		return fn(a, bs[i])
+	}, settings...)
+}